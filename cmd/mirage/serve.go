@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"mirage/internal/certs"
+	"mirage/internal/config"
+	"mirage/internal/logger"
+	"mirage/internal/proxy"
+)
+
+type listener struct {
+	net.Listener
+	scheme string
+	srv    *http.Server
+}
+
+func serve(server config.Server, handler, admin http.Handler, p *proxy.Proxy) error {
+	started := time.Now()
+
+	var tlsConfig *tls.Config
+	if server.TLSEnabled() {
+		cert, err := certs.Load(server.TLS)
+		if err != nil {
+			p.Close()
+			return err
+		}
+		reportCertificate(cert)
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert.TLS}}
+	}
+
+	listeners, err := listen(server, handler, admin, tlsConfig, p)
+	if err != nil {
+		p.Close()
+		return err
+	}
+
+	errc := make(chan error, len(listeners)+1)
+	socksServer, err := serveSocks(server, p, errc)
+	if err != nil {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+		p.Close()
+		return err
+	}
+	for _, ln := range listeners {
+		go func() {
+			if ln.scheme == "https" {
+				errc <- ln.srv.ServeTLS(ln, "", "")
+				return
+			}
+			errc <- ln.srv.Serve(ln)
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hookEnv := serverEnv(listeners[0])
+	if server.Hooks.OnStart != "" {
+		go func() {
+			if err := runHook(ctx, "on_start", server.Hooks.OnStart, hookEnv); err != nil {
+				logger.LogError(err.Error())
+			}
+		}()
+	}
+
+	var serveErr error
+	select {
+	case serveErr = <-errc:
+	case <-ctx.Done():
+		fmt.Println()
+	}
+	stop()
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), server.ShutdownTimeout)
+	defer cancel()
+	if n := p.Summary().InFlight; n > 0 {
+		logger.LogInfo(fmt.Sprintf("Shutting down, waiting up to %s for %s in flight", server.ShutdownTimeout, plural(n, "request")))
+	} else {
+		logger.LogInfo("Shutting down")
+	}
+	socksDone := make(chan error, 1)
+	if socksServer != nil {
+		go func() { socksDone <- socksServer.Shutdown(drainCtx) }()
+	} else {
+		socksDone <- nil
+	}
+	if err := shutdown(drainCtx, listeners); err != nil {
+		logger.LogWarning(fmt.Sprintf("Drain timed out after %s, aborting %s", server.ShutdownTimeout, plural(p.Summary().InFlight, "request")))
+		for _, ln := range listeners {
+			ln.srv.Close()
+		}
+	}
+	if err := <-socksDone; err != nil {
+		logger.LogWarning(fmt.Sprintf("Drain timed out after %s, closing open SOCKS connections", server.ShutdownTimeout))
+	}
+
+	summary := p.Summary()
+	if err := p.Close(); err != nil {
+		logger.LogError(fmt.Sprintf("Flushing recordings failed: %v", err))
+	}
+	printSummary(summary, time.Since(started))
+
+	if server.Hooks.OnStop != "" {
+		stopCtx, cancel := context.WithTimeout(context.Background(), server.ShutdownTimeout)
+		defer cancel()
+		env := append(hookEnv,
+			"MIRAGE_REQUESTS="+strconv.FormatInt(summary.Requests, 10),
+			"MIRAGE_FAILED="+strconv.FormatInt(summary.Failed, 10),
+		)
+		if err := runHook(stopCtx, "on_stop", server.Hooks.OnStop, env); err != nil {
+			logger.LogError(err.Error())
+		}
+	}
+
+	if errors.Is(serveErr, http.ErrServerClosed) {
+		return nil
+	}
+	return serveErr
+}
+
+func printSummary(summary proxy.Summary, uptime time.Duration) {
+	msg := fmt.Sprintf("Stopped after %s: %s", uptime.Round(time.Second), plural(summary.Requests, "request"))
+	if summary.Failed > 0 {
+		msg += fmt.Sprintf(", %d failed upstream", summary.Failed)
+	}
+	logger.LogSuccess(msg)
+	for _, rec := range summary.Recorded {
+		logger.LogSuccess(fmt.Sprintf("Saved %s to %s", plural(int64(rec.Count), "interaction"), rec.Output))
+	}
+}
+
+func plural(n int64, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func listen(server config.Server, handler, admin http.Handler, tlsConfig *tls.Config, p *proxy.Proxy) ([]listener, error) {
+	var listeners []listener
+	open := func(addr, scheme, label string, h http.Handler) error {
+		network, address, err := config.ParseAddress(addr, server.Host)
+		if err != nil {
+			return err
+		}
+		ln, err := listenOn(network, address)
+		if err != nil {
+			return err
+		}
+		if label != "" {
+			logger.LogInfo(fmt.Sprintf("%s on %s", label, describeAddr(network, ln.Addr())))
+		}
+		srv := &http.Server{Handler: h}
+		if scheme == "https" {
+			srv.TLSConfig = tlsConfig
+		}
+		listeners = append(listeners, listener{Listener: ln, scheme: scheme, srv: srv})
+		return nil
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	err := func() error {
+		if addr := server.TLSAddr(); addr != "" {
+			if err := open(addr, "https", "", handler); err != nil {
+				return err
+			}
+		}
+		if addr := server.PlainAddr(); addr != "" {
+			if err := open(addr, "http", "", handler); err != nil {
+				return err
+			}
+		}
+		for _, l := range server.Listeners {
+			h, err := p.ListenerHandler(l)
+			if err != nil {
+				return err
+			}
+			listenerScheme := "http"
+			if l.TLS {
+				listenerScheme = "https"
+			}
+			if err := open(l.Address, listenerScheme, describeListener(l), h); err != nil {
+				return err
+			}
+		}
+		if admin != nil {
+			return open(server.Dashboard.Address, scheme, "Dashboard", admin)
+		}
+		return nil
+	}()
+	if err != nil {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+		return nil, err
+	}
+	return listeners, nil
+}
+
+func listenOn(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", address); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("%s is in use by another process", address)
+			}
+			os.Remove(address)
+		}
+	}
+	return net.Listen(network, address)
+}
+
+func shutdown(ctx context.Context, listeners []listener) error {
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func() { errs <- ln.srv.Shutdown(ctx) }()
+	}
+	var err error
+	for range listeners {
+		err = errors.Join(err, <-errs)
+	}
+	return err
+}
+
+func describeListener(l config.Listener) string {
+	label := "Listener"
+	if l.Name != "" {
+		label += " " + l.Name
+	}
+	switch {
+	case l.Route != "" && len(l.Scenarios) > 0:
+		label += fmt.Sprintf(" (route %s, scenarios %s)", l.Route, strings.Join(l.Scenarios, ", "))
+	case l.Route != "":
+		label += fmt.Sprintf(" (route %s)", l.Route)
+	case len(l.Scenarios) > 0:
+		label += fmt.Sprintf(" (scenarios %s)", strings.Join(l.Scenarios, ", "))
+	}
+	return label
+}
+
+func describeAddr(network string, addr net.Addr) string {
+	if network == "unix" {
+		return "unix:" + addr.String()
+	}
+	return addr.String()
+}
+
+func reportCertificate(cert *certs.Certificate) {
+	source := "Loaded"
+	if cert.Generated {
+		source = "Generated self-signed"
+	}
+	logger.LogInfo(fmt.Sprintf("%s certificate for %s, valid until %s", source, strings.Join(cert.Hostnames, ", "), cert.NotAfter.Format("2006-01-02")))
+	logger.LogInfo("SHA-256 fingerprint: " + cert.Fingerprint)
+}
+
+func serverEnv(ln listener) []string {
+	addr := ln.Addr().String()
+	host, port, _ := net.SplitHostPort(addr)
+	return append(os.Environ(),
+		"MIRAGE_ADDR="+addr,
+		"MIRAGE_PORT="+port,
+		"MIRAGE_URL="+ln.scheme+"://"+net.JoinHostPort(config.BrowsableHost(host), port),
+		"MIRAGE_PID="+strconv.Itoa(os.Getpid()),
+	)
+}
+
+func runHook(ctx context.Context, name, command string, env []string) error {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	cmd := exec.CommandContext(ctx, shell, flag, command)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	logger.LogInfo(fmt.Sprintf("Running %s hook: %s", name, command))
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s hook stopped: %v", name, ctx.Err())
+		}
+		return fmt.Errorf("%s hook failed: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,368 @@
+package cassette
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"mirage/internal/config"
+	"mirage/internal/recorder"
+	"mirage/internal/redact"
+)
+
+const (
+	ModeOnce        = "once"
+	ModeNewEpisodes = "new_episodes"
+	ModeNone        = "none"
+	ModeAll         = "all"
+
+	HeaderName = "X-Mirage-Cassette"
+	HeaderMode = "X-Mirage-Cassette-Mode"
+
+	DefaultDir = "cassettes"
+)
+
+type Cassette struct {
+	Name string `json:"name"`
+	Mode string `json:"mode"`
+	Path string `json:"path"`
+
+	mu           sync.Mutex
+	matcher      *requestMatcher
+	allowRepeats bool
+	existed      bool
+	interactions []recorder.Interaction
+	played       []bool
+	recorded     int
+	journal      *recorder.Recorder
+	rewound      bool
+	closed       bool
+}
+
+type Status struct {
+	Name         string `json:"name"`
+	Mode         string `json:"mode"`
+	Path         string `json:"path"`
+	Interactions int    `json:"interactions"`
+	Played       int    `json:"played"`
+	Recorded     int    `json:"recorded"`
+}
+
+type Manager struct {
+	dir          string
+	mode         string
+	matcher      *requestMatcher
+	allowRepeats bool
+
+	mu        sync.Mutex
+	cassettes map[string]*Cassette
+	active    *Cassette
+}
+
+func NewManager(cfg config.Cassettes, red *redact.Redactor) (*Manager, error) {
+	m := &Manager{
+		dir:          cfg.Dir,
+		mode:         cfg.Mode,
+		allowRepeats: cfg.AllowRepeats,
+		cassettes:    make(map[string]*Cassette),
+	}
+	if m.dir == "" {
+		m.dir = DefaultDir
+	}
+	if m.mode == "" {
+		m.mode = ModeOnce
+	}
+	if err := validateMode(m.mode); err != nil {
+		return nil, fmt.Errorf("cassettes.mode: %w", err)
+	}
+
+	matcher, err := newRequestMatcher(cfg.MatchOn, red)
+	if err != nil {
+		return nil, fmt.Errorf("cassettes.match_on: %w", err)
+	}
+	m.matcher = matcher
+	return m, nil
+}
+
+func validateMode(mode string) error {
+	switch mode {
+	case ModeOnce, ModeNewEpisodes, ModeNone, ModeAll:
+		return nil
+	}
+	return fmt.Errorf("unknown cassette mode %q (use once, new_episodes, none or all)", mode)
+}
+
+func (m *Manager) Insert(name, mode string) (*Cassette, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.load(name, mode)
+	if err != nil {
+		return nil, err
+	}
+	m.active = c
+	return c, nil
+}
+
+func (m *Manager) Eject(name string) (*Cassette, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var c *Cassette
+	if name == "" || (m.active != nil && m.active.Name == name) {
+		c = m.active
+		m.active = nil
+	} else {
+		c = m.cassettes[name]
+	}
+	if c == nil {
+		return nil, nil
+	}
+	delete(m.cassettes, c.Name)
+	return c, c.close()
+}
+
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	for name, c := range m.cassettes {
+		errs = append(errs, c.close())
+		delete(m.cassettes, name)
+	}
+	m.active = nil
+	return errors.Join(errs...)
+}
+
+func (m *Manager) Active() *Cassette {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.active
+}
+
+func (m *Manager) Resolve(r *http.Request) (*Cassette, error) {
+	name := r.Header.Get(HeaderName)
+	if name == "" {
+		return m.Active(), nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.load(name, r.Header.Get(HeaderMode))
+}
+
+func (m *Manager) load(name, mode string) (*Cassette, error) {
+	if mode == "" {
+		mode = m.mode
+	}
+	if err := validateMode(mode); err != nil {
+		return nil, err
+	}
+
+	if c, ok := m.cassettes[name]; ok {
+		if err := c.setMode(mode); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	path, err := m.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cassette{
+		Name:         name,
+		Mode:         mode,
+		Path:         path,
+		matcher:      m.matcher,
+		allowRepeats: m.allowRepeats,
+	}
+
+	interactions, err := recorder.Load(path)
+	switch {
+	case err == nil:
+		c.existed = true
+		c.interactions = interactions
+	case errors.Is(err, os.ErrNotExist):
+		if mode == ModeNone {
+			return nil, fmt.Errorf("cassette %q does not exist at %s (mode none)", name, path)
+		}
+	default:
+		return nil, fmt.Errorf("loading cassette %q: %w", name, err)
+	}
+	c.played = make([]bool, len(c.interactions))
+
+	m.cassettes[name] = c
+	return c, nil
+}
+
+func (m *Manager) path(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid cassette name %q", name)
+	}
+	if filepath.Ext(clean) == "" {
+		clean += ".json"
+	}
+	return filepath.Join(m.dir, clean), nil
+}
+
+func (c *Cassette) Find(r *http.Request, body []byte) *recorder.Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Mode == ModeAll {
+		return nil
+	}
+
+	last := -1
+	for i := range c.interactions {
+		if !c.matcher.matches(r, body, &c.interactions[i].Request) {
+			continue
+		}
+		if !c.played[i] {
+			c.played[i] = true
+			return &c.interactions[i]
+		}
+		last = i
+	}
+	if last >= 0 && c.allowRepeats {
+		return &c.interactions[last]
+	}
+	return nil
+}
+
+func (c *Cassette) setMode(mode string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if mode == ModeNone && !c.existed && c.recorded == 0 {
+		return fmt.Errorf("cassette %q does not exist at %s (mode none)", c.Name, c.Path)
+	}
+	c.Mode = mode
+	return nil
+}
+
+func (c *Cassette) CanRecord() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.Mode {
+	case ModeNewEpisodes, ModeAll:
+		return true
+	case ModeOnce:
+		return !c.existed
+	}
+	return false
+}
+
+func (c *Cassette) Add(i recorder.Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return fmt.Errorf("cassette %q was ejected", c.Name)
+	}
+	if c.Mode == ModeAll && !c.rewound {
+		if err := c.rewind(); err != nil {
+			return err
+		}
+	}
+	if c.journal == nil {
+		if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+			return err
+		}
+		journal, err := recorder.NewRecorder(config.Recording{Output: c.Path, Format: recorder.FormatJSON})
+		if err != nil {
+			return err
+		}
+		c.journal = journal
+	}
+	if err := c.journal.Append(i); err != nil {
+		return err
+	}
+
+	c.interactions = append(c.interactions, i)
+	c.played = append(c.played, true)
+	c.recorded++
+	return nil
+}
+
+func (c *Cassette) rewind() error {
+	if c.journal != nil {
+		err := c.journal.Close()
+		c.journal = nil
+		if err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(c.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	c.interactions = nil
+	c.played = nil
+	c.recorded = 0
+	c.rewound = true
+	return nil
+}
+
+func (c *Cassette) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.journal == nil {
+		return nil
+	}
+	err := c.journal.Close()
+	c.journal = nil
+	return err
+}
+
+func Play(w http.ResponseWriter, i *recorder.Interaction) (int, error) {
+	body, err := i.Response.RawBody()
+	if err != nil {
+		return 0, err
+	}
+
+	for k, vv := range i.Response.Headers {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	w.Header().Del("Content-Length")
+
+	status := i.Response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, err = w.Write(body)
+	return status, err
+}
+
+func (c *Cassette) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	played := 0
+	for _, p := range c.played {
+		if p {
+			played++
+		}
+	}
+	return Status{
+		Name:         c.Name,
+		Mode:         c.Mode,
+		Path:         c.Path,
+		Interactions: len(c.interactions),
+		Played:       played - c.recorded,
+		Recorded:     c.recorded,
+	}
+}
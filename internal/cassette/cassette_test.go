@@ -0,0 +1,142 @@
+package cassette
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mirage/internal/config"
+	"mirage/internal/recorder"
+)
+
+func recorded(url string) recorder.Interaction {
+	return recorder.Interaction{
+		Request:  recorder.ReqDetail{Method: "GET", URL: url},
+		Response: recorder.RespDetail{Status: 200, Body: url},
+	}
+}
+
+func urls(t *testing.T, path string) string {
+	t.Helper()
+	interactions, err := recorder.Load(path)
+	if err != nil {
+		return "missing"
+	}
+	out := make([]string, len(interactions))
+	for i, in := range interactions {
+		out[i] = strings.TrimPrefix(in.Request.URL, "http://api.test")
+	}
+	return strings.Join(out, ",")
+}
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		mode      string
+		existing  bool
+		wantErr   bool
+		replays   bool
+		canRecord bool
+		wantFile  string
+	}{
+		{mode: ModeOnce, existing: true, replays: true, canRecord: false, wantFile: "/a"},
+		{mode: ModeOnce, existing: false, canRecord: true, wantFile: "/b"},
+		{mode: ModeNewEpisodes, existing: true, replays: true, canRecord: true, wantFile: "/a,/b"},
+		{mode: ModeNewEpisodes, existing: false, canRecord: true, wantFile: "/b"},
+		{mode: ModeNone, existing: true, replays: true, canRecord: false, wantFile: "/a"},
+		{mode: ModeNone, existing: false, wantErr: true, wantFile: "missing"},
+		{mode: ModeAll, existing: true, replays: false, canRecord: true, wantFile: "/b"},
+		{mode: ModeAll, existing: false, canRecord: true, wantFile: "/b"},
+	}
+
+	for _, tt := range tests {
+		name := tt.mode + "/new"
+		if tt.existing {
+			name = tt.mode + "/existing"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "tape.json")
+			if tt.existing {
+				if err := recorder.Save(path, recorder.FormatJSON, []recorder.Interaction{recorded("http://api.test/a")}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m, err := NewManager(config.Cassettes{Dir: dir}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := m.Insert("tape", tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Insert err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if got := urls(t, path); got != tt.wantFile {
+					t.Errorf("file = %q, want %q", got, tt.wantFile)
+				}
+				return
+			}
+
+			if got := c.Find(httptest.NewRequest("GET", "http://api.test/a", nil), nil) != nil; got != tt.replays {
+				t.Errorf("replays /a = %v, want %v", got, tt.replays)
+			}
+			if c.Find(httptest.NewRequest("GET", "http://api.test/b", nil), nil) != nil {
+				t.Error("unexpected match for /b")
+			}
+			if got := c.CanRecord(); got != tt.canRecord {
+				t.Fatalf("CanRecord = %v, want %v", got, tt.canRecord)
+			}
+			if tt.canRecord {
+				if err := c.Add(recorded("http://api.test/b")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := m.Eject(""); err != nil {
+				t.Fatal(err)
+			}
+			if got := urls(t, path); got != tt.wantFile {
+				t.Errorf("file = %q, want %q", got, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestModeChangeKeepsPlayed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tape.json")
+	if err := recorder.Save(path, recorder.FormatJSON, []recorder.Interaction{recorded("http://api.test/a")}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(config.Cassettes{Dir: dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "http://api.test/a", nil)
+	req.Header.Set(HeaderName, "tape")
+	req.Header.Set(HeaderMode, ModeNewEpisodes)
+	c, err := m.Resolve(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Find(req, nil) == nil {
+		t.Fatal("expected /a to replay")
+	}
+
+	req.Header.Set(HeaderMode, ModeNone)
+	again, err := m.Resolve(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != c || again.Mode != ModeNone {
+		t.Fatalf("got cassette %p mode %q, want %p mode none", again, again.Mode, c)
+	}
+	if again.Find(req, nil) != nil {
+		t.Error("/a replayed twice")
+	}
+	if got := again.Status().Played; got != 1 {
+		t.Errorf("played = %d, want 1", got)
+	}
+}
@@ -0,0 +1,135 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"mirage/internal/recorder"
+	"mirage/internal/redact"
+)
+
+var defaultMatchOn = []string{"method", "uri"}
+
+type requestMatcher struct {
+	rules    []string
+	headers  []string
+	redactor *redact.Redactor
+}
+
+func newRequestMatcher(rules []string, red *redact.Redactor) (*requestMatcher, error) {
+	if len(rules) == 0 {
+		rules = defaultMatchOn
+	}
+
+	m := &requestMatcher{redactor: red}
+	for _, rule := range rules {
+		if name, ok := strings.CutPrefix(rule, "header:"); ok {
+			m.headers = append(m.headers, http.CanonicalHeaderKey(name))
+			continue
+		}
+		switch rule {
+		case "method", "uri", "host", "path", "query", "body":
+			m.rules = append(m.rules, rule)
+		default:
+			return nil, fmt.Errorf("unknown match rule %q (use method, uri, host, path, query, body or header:<name>)", rule)
+		}
+	}
+	return m, nil
+}
+
+func (m *requestMatcher) matches(r *http.Request, body []byte, recorded *recorder.ReqDetail) bool {
+	recordedURL, err := url.Parse(recorded.URL)
+	if err != nil {
+		return false
+	}
+
+	for _, rule := range m.rules {
+		switch rule {
+		case "method":
+			if r.Method != recorded.Method {
+				return false
+			}
+		case "uri":
+			live := r.URL.String()
+			if live != recorded.URL && m.redactor.URL(live) != recorded.URL {
+				return false
+			}
+		case "host":
+			if requestHost(r) != recordedURL.Host {
+				return false
+			}
+		case "path":
+			if r.URL.Path != recordedURL.Path {
+				return false
+			}
+		case "query":
+			if !m.queryMatches(r.URL, recordedURL.Query()) {
+				return false
+			}
+		case "body":
+			recordedBody, err := recorded.RawBody()
+			if err != nil || !m.bodyMatches(r.Header.Get("Content-Type"), body, recordedBody) {
+				return false
+			}
+		}
+	}
+
+	recordedHeaders := http.Header(recorded.Headers)
+	for _, name := range m.headers {
+		live := r.Header.Get(name)
+		want := recordedHeaders.Get(name)
+		if live != want && m.redactor.Header(http.Header{name: {live}}).Get(name) != want {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *requestMatcher) queryMatches(live *url.URL, recorded url.Values) bool {
+	recorded = normalizeQuery(recorded)
+	if reflect.DeepEqual(normalizeQuery(live.Query()), recorded) {
+		return true
+	}
+	redacted, err := url.Parse(m.redactor.URL(live.String()))
+	return err == nil && reflect.DeepEqual(normalizeQuery(redacted.Query()), recorded)
+}
+
+func (m *requestMatcher) bodyMatches(contentType string, live, recorded []byte) bool {
+	if bodiesEqual(live, recorded) {
+		return true
+	}
+	if m.redactor == nil {
+		return false
+	}
+	return bodiesEqual([]byte(m.redactor.Body(contentType, string(live))), recorded)
+}
+
+func requestHost(r *http.Request) string {
+	if r.URL.Host != "" {
+		return r.URL.Host
+	}
+	return r.Host
+}
+
+func normalizeQuery(q url.Values) url.Values {
+	if len(q) == 0 {
+		return nil
+	}
+	return q
+}
+
+func bodiesEqual(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,172 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mirage/internal/config"
+)
+
+const validity = 365 * 24 * time.Hour
+
+var DefaultHostnames = []string{"localhost", "127.0.0.1", "::1"}
+
+type Certificate struct {
+	TLS         tls.Certificate
+	Hostnames   []string
+	Fingerprint string
+	Generated   bool
+	NotAfter    time.Time
+}
+
+func Load(cfg config.TLS) (*Certificate, error) {
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		return nil, errors.New("TLS requires both a certificate and a key")
+	}
+	if !cfg.SelfSigned {
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate: %w", err)
+		}
+		return describe(cert, false)
+	}
+
+	hostnames := cfg.Hostnames
+	if len(hostnames) == 0 {
+		hostnames = DefaultHostnames
+	}
+
+	if cfg.Cert != "" {
+		if cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key); err == nil {
+			c, err := describe(cert, false)
+			if err == nil && c.covers(hostnames) && time.Now().Before(c.NotAfter) {
+				return c, nil
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("loading certificate: %w", err)
+		}
+	}
+
+	certPEM, keyPEM, err := SelfSigned(hostnames)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Cert != "" {
+		if err := writePEM(cfg.Cert, certPEM, 0644); err != nil {
+			return nil, err
+		}
+		if err := writePEM(cfg.Key, keyPEM, 0600); err != nil {
+			return nil, err
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return describe(cert, true)
+}
+
+func SelfSigned(hostnames []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostnames[0], Organization: []string{"mirage self-signed"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hostnames {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+func describe(cert tls.Certificate, generated bool) (*Certificate, error) {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf = leaf
+
+	hostnames := append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		hostnames = append(hostnames, ip.String())
+	}
+	if len(hostnames) == 0 && leaf.Subject.CommonName != "" {
+		hostnames = []string{leaf.Subject.CommonName}
+	}
+
+	return &Certificate{
+		TLS:         cert,
+		Hostnames:   hostnames,
+		Fingerprint: fingerprint(leaf.Raw),
+		Generated:   generated,
+		NotAfter:    leaf.NotAfter,
+	}, nil
+}
+
+func (c *Certificate) covers(hostnames []string) bool {
+	for _, h := range hostnames {
+		if c.TLS.Leaf.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func writePEM(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, perm)
+}
@@ -1,16 +1,249 @@
 package config
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"mirage/internal/jsonpatch"
+
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	DefaultFile   = "mirage.yaml"
+	DefaultPort   = 8080
+	DefaultOutput = "traffic.json"
+	DefaultHost   = "localhost"
+
+	DefaultDashboardAddress = "localhost:9090"
+
+	DefaultUpstreamTimeout = 2 * time.Minute
+	DefaultDialTimeout     = 10 * time.Second
+	DefaultTLSTimeout      = 10 * time.Second
+	DefaultHeaderTimeout   = 60 * time.Second
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultRetryMaxBackoff = 2 * time.Second
+	DefaultShutdownTimeout = 10 * time.Second
+)
+
 type Config struct {
+	Server    Server     `yaml:"server"`
+	Redact    Redaction  `yaml:"redact"`
+	Cassettes Cassettes  `yaml:"cassettes"`
+	Rewrites  []Rewrite  `yaml:"rewrites"`
+	Routes    []Route    `yaml:"routes"`
 	Scenarios []Scenario `yaml:"scenarios"`
 }
 
+type Server struct {
+	Host      string    `yaml:"host"`
+	Port      int       `yaml:"port"`
+	TLS       TLS       `yaml:"tls"`
+	Socks     Socks     `yaml:"socks"`
+	Upstream  Upstream  `yaml:"upstream"`
+	Recording Recording `yaml:"recording"`
+	Dashboard Dashboard `yaml:"dashboard"`
+	LogFormat string    `yaml:"log_format"`
+	Defaults  Defaults  `yaml:"defaults"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	Hooks           Hooks         `yaml:"hooks"`
+	Listeners       []Listener    `yaml:"listeners"`
+}
+
+type Listener struct {
+	Name      string   `yaml:"name"`
+	Address   string   `yaml:"address"`
+	Route     string   `yaml:"route"`
+	Scenarios []string `yaml:"scenarios"`
+	TLS       bool     `yaml:"tls"`
+}
+
+type Hooks struct {
+	OnStart string `yaml:"on_start"`
+	OnStop  string `yaml:"on_stop"`
+}
+
+type TLS struct {
+	Cert       string   `yaml:"cert"`
+	Key        string   `yaml:"key"`
+	SelfSigned bool     `yaml:"self_signed"`
+	Hostnames  []string `yaml:"hostnames"`
+	Port       int      `yaml:"port"`
+}
+
+type Socks struct {
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type Upstream struct {
+	Timeout         time.Duration  `yaml:"timeout"`
+	DialTimeout     time.Duration  `yaml:"dial_timeout"`
+	TLSTimeout      time.Duration  `yaml:"tls_timeout"`
+	HeaderTimeout   time.Duration  `yaml:"response_header_timeout"`
+	Retry           Retry          `yaml:"retry"`
+	Pool            Pool           `yaml:"pool"`
+	FollowRedirects bool           `yaml:"follow_redirects"`
+	Proxy           UpstreamProxy  `yaml:"proxy"`
+	TLS             UpstreamTLS    `yaml:"tls"`
+	Hosts           []UpstreamHost `yaml:"hosts"`
+}
+
+type Retry struct {
+	Attempts   int           `yaml:"attempts"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	Statuses   []int         `yaml:"statuses"`
+}
+
+type Pool struct {
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+}
+
+type UpstreamTLS struct {
+	CAFiles            []string `yaml:"ca_files"`
+	ClientCert         string   `yaml:"client_cert"`
+	ClientKey          string   `yaml:"client_key"`
+	ServerName         string   `yaml:"server_name"`
+	MinVersion         string   `yaml:"min_version"`
+	InsecureSkipVerify *bool    `yaml:"insecure_skip_verify"`
+}
+
+type UpstreamHost struct {
+	Match string      `yaml:"match"`
+	TLS   UpstreamTLS `yaml:"tls"`
+}
+
+type UpstreamProxy struct {
+	URL      string   `yaml:"url"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	NoProxy  []string `yaml:"no_proxy"`
+}
+
+type Recording struct {
+	Enabled       bool          `yaml:"enabled"`
+	Output        string        `yaml:"output"`
+	Format        string        `yaml:"format"`
+	Fsync         string        `yaml:"fsync"`
+	FsyncInterval time.Duration `yaml:"fsync_interval"`
+	MaxSizeMB     int           `yaml:"max_size_mb"`
+	MaxAge        time.Duration `yaml:"max_age"`
+	Compress      bool          `yaml:"compress"`
+	Include       RecordFilter  `yaml:"include"`
+	Exclude       RecordFilter  `yaml:"exclude"`
+}
+
+type RecordFilter struct {
+	Hosts        []string `yaml:"hosts"`
+	Paths        []string `yaml:"paths"`
+	Methods      []string `yaml:"methods"`
+	Status       []string `yaml:"status"`
+	ContentTypes []string `yaml:"content_types"`
+}
+
+type Dashboard struct {
+	Disabled  bool   `yaml:"disabled"`
+	NoBrowser bool   `yaml:"no_browser"`
+	Address   string `yaml:"address"`
+}
+
+type Defaults struct {
+	Headers map[string]string `yaml:"headers"`
+	Delay   time.Duration     `yaml:"delay"`
+}
+
+type Cassettes struct {
+	Dir          string   `yaml:"dir"`
+	Mode         string   `yaml:"mode"`
+	MatchOn      []string `yaml:"match_on"`
+	AllowRepeats bool     `yaml:"allow_repeats"`
+}
+
+type Redaction struct {
+	Strategy    string   `yaml:"strategy"`
+	Placeholder string   `yaml:"placeholder"`
+	Headers     []string `yaml:"headers"`
+	QueryParams []string `yaml:"query_params"`
+	JSONPaths   []string `yaml:"json_paths"`
+	FormFields  []string `yaml:"form_fields"`
+	Patterns    []string `yaml:"patterns"`
+}
+
+type Rewrite struct {
+	Name     string          `yaml:"name"`
+	Match    Match           `yaml:"match"`
+	Request  RequestRewrite  `yaml:"request"`
+	Response ResponseRewrite `yaml:"response"`
+}
+
+type RequestRewrite struct {
+	URL           string            `yaml:"url"`
+	PathReplace   []Replace         `yaml:"path_replace"`
+	SetHeaders    map[string]string `yaml:"set_headers"`
+	RemoveHeaders []string          `yaml:"remove_headers"`
+	RemoveCookies []string          `yaml:"remove_cookies"`
+	BodyEdit      `yaml:",inline"`
+}
+
+type ResponseRewrite struct {
+	Status        int               `yaml:"status"`
+	SetHeaders    map[string]string `yaml:"set_headers"`
+	RemoveHeaders []string          `yaml:"remove_headers"`
+	BodyEdit      `yaml:",inline"`
+}
+
+type BodyEdit struct {
+	BodyReplace []Replace      `yaml:"body_replace"`
+	JSONSet     map[string]any `yaml:"json_set"`
+	JSONDelete  []string       `yaml:"json_delete"`
+}
+
+type Replace struct {
+	Pattern string `yaml:"pattern"`
+	With    string `yaml:"with"`
+}
+
+type Route struct {
+	Name        string         `yaml:"name"`
+	Match       RouteMatch     `yaml:"match"`
+	Upstream    string         `yaml:"upstream"`
+	StripPrefix bool           `yaml:"strip_prefix"`
+	Recording   RouteRecording `yaml:"recording"`
+	Faults      Faults         `yaml:"faults"`
+	Scenarios   []Scenario     `yaml:"scenarios"`
+}
+
+type RouteMatch struct {
+	Host       string `yaml:"host"`
+	PathPrefix string `yaml:"path_prefix"`
+}
+
+type RouteRecording struct {
+	Output string `yaml:"output"`
+}
+
+type Faults struct {
+	Delay       time.Duration `yaml:"delay"`
+	Jitter      time.Duration `yaml:"jitter"`
+	ErrorRate   float64       `yaml:"error_rate"`
+	ErrorStatus int           `yaml:"error_status"`
+}
+
 type Scenario struct {
 	Name     string   `yaml:"name"`
 	Match    Match    `yaml:"match"`
@@ -24,10 +257,28 @@ type Match struct {
 }
 
 type Response struct {
-	Status  int               `yaml:"status"`
-	Headers map[string]string `yaml:"headers"`
-	Body    string            `yaml:"body"`
-	Delay   time.Duration     `yaml:"delay"`
+	Mode       string                `yaml:"mode"`
+	Status     int                   `yaml:"status"`
+	Headers    map[string]string     `yaml:"headers"`
+	Body       string                `yaml:"body"`
+	Delay      time.Duration         `yaml:"delay"`
+	JSONPatch  []jsonpatch.Operation `yaml:"json_patch"`
+	MergePatch any                   `yaml:"merge_patch"`
+}
+
+const (
+	ResponseModeMock  = "mock"
+	ResponseModePatch = "patch"
+)
+
+func (r Response) Patches() bool {
+	return r.Mode == ResponseModePatch
+}
+
+func Default() *Config {
+	cfg := &Config{}
+	cfg.Server.applyDefaults()
+	return cfg
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -36,10 +287,449 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
 		return nil, err
 	}
+	if err := interpolateNode(&root, filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var cfg Config
+	if len(root.Content) > 0 {
+		if err := root.Decode(&cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	cfg.Server.applyDefaults()
+	cfg.applyResponseDefaults()
 
 	return &cfg, nil
 }
+
+func (s Server) Addr() string {
+	return net.JoinHostPort(s.Host, fmt.Sprint(s.Port))
+}
+
+func (s Server) SocksAddr() string {
+	if s.Socks.Port == 0 {
+		return ""
+	}
+	return net.JoinHostPort(s.Host, fmt.Sprint(s.Socks.Port))
+}
+
+func (s Server) TLSEnabled() bool {
+	return s.TLS.SelfSigned || s.TLS.Cert != "" || s.TLS.Key != ""
+}
+
+func (s Server) TLSAddr() string {
+	if !s.TLSEnabled() {
+		return ""
+	}
+	if s.TLS.Port == 0 {
+		return s.Addr()
+	}
+	return net.JoinHostPort(s.Host, fmt.Sprint(s.TLS.Port))
+}
+
+func (s Server) BaseURL() string {
+	scheme, port := "http", s.Port
+	if s.TLSEnabled() {
+		scheme = "https"
+		if s.TLS.Port != 0 {
+			port = s.TLS.Port
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(BrowsableHost(s.Host), fmt.Sprint(port))
+}
+
+func (s Server) DashboardURL() string {
+	address := s.Dashboard.Address
+	if address == "" {
+		address = DefaultDashboardAddress
+	}
+	network, addr, err := ParseAddress(address, s.Host)
+	if err != nil || network == "unix" {
+		return ""
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	scheme := "http"
+	if s.TLSEnabled() {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(BrowsableHost(host), port) + "/__mirage/"
+}
+
+func BrowsableHost(host string) string {
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		return "localhost"
+	}
+	return host
+}
+
+const unixPrefix = "unix:"
+
+func ParseAddress(addr, defaultHost string) (network, address string, err error) {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		if path == "" {
+			return "", "", fmt.Errorf("missing socket path in %q", addr)
+		}
+		return "unix", path, nil
+	}
+	if port, err := strconv.Atoi(addr); err == nil {
+		if port < 1 || port > 65535 {
+			return "", "", fmt.Errorf("port %d is out of range", port)
+		}
+		return "tcp", net.JoinHostPort(defaultHost, addr), nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid address %q (use host:port, [ipv6]:port, a port, or unix:/path)", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", "", fmt.Errorf("invalid port in %q", addr)
+	}
+	return "tcp", net.JoinHostPort(host, port), nil
+}
+
+func (s Server) PlainAddr() string {
+	if s.TLSEnabled() && s.TLS.Port == 0 {
+		return ""
+	}
+	return s.Addr()
+}
+
+func (s *Server) applyDefaults() {
+	if s.Host == "" {
+		s.Host = DefaultHost
+	}
+	if s.Port == 0 {
+		s.Port = DefaultPort
+	}
+	if s.Recording.Output == "" {
+		s.Recording.Output = DefaultOutput
+	}
+	if s.LogFormat == "" {
+		s.LogFormat = "text"
+	}
+	if s.Dashboard.Address == "" {
+		s.Dashboard.Address = DefaultDashboardAddress
+	}
+	if s.ShutdownTimeout == 0 {
+		s.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	s.Upstream.applyDefaults()
+}
+
+func (u *Upstream) applyDefaults() {
+	if u.Timeout == 0 {
+		u.Timeout = DefaultUpstreamTimeout
+	}
+	if u.DialTimeout == 0 {
+		u.DialTimeout = DefaultDialTimeout
+	}
+	if u.TLSTimeout == 0 {
+		u.TLSTimeout = DefaultTLSTimeout
+	}
+	if u.HeaderTimeout == 0 {
+		u.HeaderTimeout = DefaultHeaderTimeout
+	}
+	if u.Retry.Backoff == 0 {
+		u.Retry.Backoff = DefaultRetryBackoff
+	}
+	if u.Retry.MaxBackoff == 0 {
+		u.Retry.MaxBackoff = DefaultRetryMaxBackoff
+	}
+}
+
+func (c *Config) applyResponseDefaults() {
+	c.applyScenarioDefaults(c.Scenarios)
+	for i := range c.Routes {
+		c.applyScenarioDefaults(c.Routes[i].Scenarios)
+		if c.Routes[i].Faults.ErrorStatus == 0 {
+			c.Routes[i].Faults.ErrorStatus = http.StatusServiceUnavailable
+		}
+	}
+}
+
+func (c *Config) applyScenarioDefaults(scenarios []Scenario) {
+	for i := range scenarios {
+		resp := &scenarios[i].Response
+		if resp.Delay == 0 {
+			resp.Delay = c.Server.Defaults.Delay
+		}
+		if len(c.Server.Defaults.Headers) == 0 || resp.Patches() {
+			continue
+		}
+		headers := make(map[string]string, len(c.Server.Defaults.Headers)+len(resp.Headers))
+		for k, v := range c.Server.Defaults.Headers {
+			headers[k] = v
+		}
+		for k, v := range resp.Headers {
+			headers[k] = v
+		}
+		resp.Headers = headers
+	}
+}
+
+func (c *Config) Validate() error {
+	return c.validate()
+}
+
+func (c *Config) validate() error {
+	if c.Server.Port < 0 || c.Server.Port > 65535 {
+		return fmt.Errorf("server.port %d is out of range", c.Server.Port)
+	}
+	if c.Server.Socks.Port < 0 || c.Server.Socks.Port > 65535 {
+		return fmt.Errorf("server.socks.port %d is out of range", c.Server.Socks.Port)
+	}
+	if c.Server.Dashboard.Address != "" {
+		if _, _, err := ParseAddress(c.Server.Dashboard.Address, DefaultHost); err != nil {
+			return fmt.Errorf("server.dashboard.address: %w", err)
+		}
+	}
+	if err := c.validateListeners(); err != nil {
+		return err
+	}
+	if c.Server.ShutdownTimeout < 0 {
+		return fmt.Errorf("server.shutdown_timeout must not be negative")
+	}
+	if c.Server.Socks.Password != "" && c.Server.Socks.Username == "" {
+		return fmt.Errorf("server.socks.password requires a username")
+	}
+	if (c.Server.TLS.Cert == "") != (c.Server.TLS.Key == "") {
+		return fmt.Errorf("server.tls requires both cert and key")
+	}
+	if c.Server.TLS.Port < 0 || c.Server.TLS.Port > 65535 {
+		return fmt.Errorf("server.tls.port %d is out of range", c.Server.TLS.Port)
+	}
+	if c.Server.TLS.Port != 0 {
+		if !c.Server.TLSEnabled() {
+			return fmt.Errorf("server.tls.port requires a certificate or self_signed: true")
+		}
+		port := c.Server.Port
+		if port == 0 {
+			port = DefaultPort
+		}
+		if c.Server.TLS.Port == port {
+			return fmt.Errorf("server.tls.port must differ from server.port (%d)", port)
+		}
+	}
+	if len(c.Server.TLS.Hostnames) > 0 && !c.Server.TLS.SelfSigned {
+		return fmt.Errorf("server.tls.hostnames requires self_signed: true")
+	}
+	if err := c.Server.Upstream.Proxy.Validate(); err != nil {
+		return fmt.Errorf("server.upstream.proxy: %w", err)
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"timeout", c.Server.Upstream.Timeout},
+		{"dial_timeout", c.Server.Upstream.DialTimeout},
+		{"tls_timeout", c.Server.Upstream.TLSTimeout},
+		{"response_header_timeout", c.Server.Upstream.HeaderTimeout},
+		{"retry.backoff", c.Server.Upstream.Retry.Backoff},
+		{"retry.max_backoff", c.Server.Upstream.Retry.MaxBackoff},
+	} {
+		if d.value < 0 {
+			return fmt.Errorf("server.upstream.%s must not be negative", d.name)
+		}
+	}
+	if c.Server.Upstream.Retry.Attempts < 0 {
+		return fmt.Errorf("server.upstream.retry.attempts must not be negative")
+	}
+	for _, code := range c.Server.Upstream.Retry.Statuses {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("server.upstream.retry.statuses: %d is not an HTTP status", code)
+		}
+	}
+	if err := c.Server.Upstream.TLS.validate(); err != nil {
+		return fmt.Errorf("server.upstream.tls: %w", err)
+	}
+	for i, h := range c.Server.Upstream.Hosts {
+		if h.Match == "" {
+			return fmt.Errorf("server.upstream.hosts[%d]: match is required", i)
+		}
+		if _, err := path.Match(h.Match, ""); err != nil {
+			return fmt.Errorf("server.upstream.hosts[%d]: invalid match %q: %w", i, h.Match, err)
+		}
+		if err := h.TLS.validate(); err != nil {
+			return fmt.Errorf("server.upstream.hosts[%d].tls: %w", i, err)
+		}
+	}
+	for _, sc := range c.Scenarios {
+		if err := sc.Response.validate(); err != nil {
+			return fmt.Errorf("scenario %q: %w", sc.Name, err)
+		}
+	}
+	names := make(map[string]bool, len(c.Routes))
+	for i, rt := range c.Routes {
+		if rt.Name == "" {
+			return fmt.Errorf("routes[%d]: name is required", i)
+		}
+		if names[rt.Name] {
+			return fmt.Errorf("routes[%d]: duplicate name %q", i, rt.Name)
+		}
+		names[rt.Name] = true
+		if err := rt.validate(); err != nil {
+			return fmt.Errorf("route %q: %w", rt.Name, err)
+		}
+	}
+	switch c.Server.LogFormat {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("server.log_format must be \"text\" or \"json\", got %q", c.Server.LogFormat)
+	}
+	return nil
+}
+
+func (c *Config) validateListeners() error {
+	routes := make(map[string]bool, len(c.Routes))
+	for _, rt := range c.Routes {
+		routes[rt.Name] = true
+	}
+	scenarios := make(map[string]bool, len(c.Scenarios))
+	for _, sc := range c.Scenarios {
+		scenarios[sc.Name] = true
+	}
+
+	for i, l := range c.Server.Listeners {
+		if l.Address == "" {
+			return fmt.Errorf("server.listeners[%d]: address is required", i)
+		}
+		if _, _, err := ParseAddress(l.Address, DefaultHost); err != nil {
+			return fmt.Errorf("server.listeners[%d]: %w", i, err)
+		}
+		if l.TLS && !c.Server.TLSEnabled() {
+			return fmt.Errorf("server.listeners[%d]: tls requires a certificate or self_signed: true", i)
+		}
+		if l.Route != "" && !routes[l.Route] {
+			return fmt.Errorf("server.listeners[%d]: unknown route %q", i, l.Route)
+		}
+		for _, name := range l.Scenarios {
+			if !scenarios[name] {
+				return fmt.Errorf("server.listeners[%d]: unknown scenario %q", i, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (r Route) validate() error {
+	u, err := url.Parse(r.Upstream)
+	if err != nil {
+		return fmt.Errorf("invalid upstream %q: %w", r.Upstream, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("upstream must be an http or https base URL, got %q", r.Upstream)
+	}
+	if r.Match.PathPrefix != "" && !strings.HasPrefix(r.Match.PathPrefix, "/") {
+		return fmt.Errorf("match.path_prefix must start with /, got %q", r.Match.PathPrefix)
+	}
+	if _, err := path.Match(r.Match.Host, ""); err != nil {
+		return fmt.Errorf("invalid match.host %q: %w", r.Match.Host, err)
+	}
+	if r.StripPrefix && r.Match.PathPrefix == "" {
+		return fmt.Errorf("strip_prefix requires match.path_prefix")
+	}
+	if r.Faults.ErrorRate < 0 || r.Faults.ErrorRate > 1 {
+		return fmt.Errorf("faults.error_rate must be between 0 and 1, got %v", r.Faults.ErrorRate)
+	}
+	if r.Faults.ErrorStatus != 0 && (r.Faults.ErrorStatus < 100 || r.Faults.ErrorStatus > 599) {
+		return fmt.Errorf("faults.error_status %d is not an HTTP status", r.Faults.ErrorStatus)
+	}
+	if r.Faults.Delay < 0 || r.Faults.Jitter < 0 {
+		return fmt.Errorf("faults.delay and faults.jitter must not be negative")
+	}
+	for _, sc := range r.Scenarios {
+		if err := sc.Response.validate(); err != nil {
+			return fmt.Errorf("scenario %q: %w", sc.Name, err)
+		}
+	}
+	return nil
+}
+
+const ProxyDirect = "direct"
+
+func (p UpstreamProxy) Validate() error {
+	if p.URL == "" || p.URL == ProxyDirect {
+		return nil
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url %q: %w", p.URL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("unsupported scheme %q in %q (use http, https, socks5 or socks5h)", u.Scheme, u.Redacted())
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in %q", u.Redacted())
+	}
+	return nil
+}
+
+func (r Response) validate() error {
+	switch r.Mode {
+	case "", ResponseModeMock:
+		if len(r.JSONPatch) > 0 || r.MergePatch != nil {
+			return fmt.Errorf("json_patch and merge_patch require response.mode: patch")
+		}
+	case ResponseModePatch:
+		if r.Body != "" {
+			return fmt.Errorf("response.body cannot be used with mode: patch (use json_patch or merge_patch)")
+		}
+		if err := jsonpatch.Validate(r.JSONPatch); err != nil {
+			return fmt.Errorf("json_patch: %w", err)
+		}
+	default:
+		return fmt.Errorf("response.mode must be \"mock\" or \"patch\", got %q", r.Mode)
+	}
+	return nil
+}
+
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (t UpstreamTLS) validate() error {
+	if (t.ClientCert == "") != (t.ClientKey == "") {
+		return fmt.Errorf("client_cert and client_key must be set together")
+	}
+	if _, ok := TLSVersions[t.MinVersion]; t.MinVersion != "" && !ok {
+		return fmt.Errorf("min_version must be one of 1.0, 1.1, 1.2, 1.3, got %q", t.MinVersion)
+	}
+	return nil
+}
+
+func (t UpstreamTLS) Merge(override UpstreamTLS) UpstreamTLS {
+	merged := t
+	merged.CAFiles = append(append([]string(nil), t.CAFiles...), override.CAFiles...)
+	if override.ClientCert != "" {
+		merged.ClientCert, merged.ClientKey = override.ClientCert, override.ClientKey
+	}
+	if override.ServerName != "" {
+		merged.ServerName = override.ServerName
+	}
+	if override.MinVersion != "" {
+		merged.MinVersion = override.MinVersion
+	}
+	if override.InsecureSkipVerify != nil {
+		merged.InsecureSkipVerify = override.InsecureSkipVerify
+	}
+	return merged
+}
+
+func (t UpstreamTLS) Insecure() bool {
+	return t.InsecureSkipVerify != nil && *t.InsecureSkipVerify
+}
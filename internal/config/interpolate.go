@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"mirage/internal/secrets"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	placeholderPattern = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+	sensitiveNames     = regexp.MustCompile(`(?i)token|secret|passw|apikey|privatekey|(^|[_.-])key($|[_.-])|auth|credential|cookie|session`)
+)
+
+type interpolator struct {
+	baseDir string
+	lookup  func(string) (string, bool)
+	errs    []string
+}
+
+func interpolateNode(node *yaml.Node, baseDir string) error {
+	in := &interpolator{baseDir: baseDir, lookup: os.LookupEnv}
+	in.walk(node)
+	if len(in.errs) > 0 {
+		return fmt.Errorf("interpolation failed:\n  %s", strings.Join(in.errs, "\n  "))
+	}
+	return nil
+}
+
+func (in *interpolator) walk(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		if strings.Contains(node.Value, "${") {
+			node.Value = in.expand(node.Value, node.Line)
+			node.Tag = ""
+		}
+		return
+	}
+	for _, child := range node.Content {
+		in.walk(child)
+	}
+}
+
+func (in *interpolator) expand(value string, line int) string {
+	return placeholderPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		expr := match[2 : len(match)-1]
+		resolved, err := in.resolve(expr)
+		if err != nil {
+			in.errs = append(in.errs, fmt.Sprintf("line %d: %v", line, err))
+			return match
+		}
+		return resolved
+	})
+}
+
+func (in *interpolator) resolve(expr string) (string, error) {
+	if path, ok := strings.CutPrefix(expr, "file:"); ok {
+		return in.readFile(path)
+	}
+
+	name, fallback, hasFallback := strings.Cut(expr, ":-")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("empty variable reference ${%s}", expr)
+	}
+
+	value, ok := in.lookup(name)
+	if hasFallback && value == "" {
+		return fallback, nil
+	}
+	if ok {
+		if sensitiveNames.MatchString(name) {
+			secrets.Register(name, value)
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("undefined variable %s (use ${%s:-default} to provide a fallback)", name, name)
+}
+
+func (in *interpolator) readFile(path string) (string, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", fmt.Errorf("empty file reference")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(in.baseDir, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading secret file: %w", err)
+	}
+
+	value := strings.TrimRight(string(data), "\r\n")
+	secrets.Register("file:"+filepath.Base(path), value)
+	return value, nil
+}
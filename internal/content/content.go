@@ -0,0 +1,137 @@
+package content
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+)
+
+func Normalize(contentEncoding string) string {
+	enc := strings.ToLower(strings.TrimSpace(contentEncoding))
+	switch enc {
+	case "", "identity":
+		return ""
+	case "x-gzip":
+		return "gzip"
+	}
+	return enc
+}
+
+func Supported(contentEncoding string) bool {
+	switch Normalize(contentEncoding) {
+	case "", "gzip", "deflate", "br":
+		return true
+	}
+	return false
+}
+
+func Decode(contentEncoding string, data []byte) ([]byte, error) {
+	var r io.Reader
+	switch enc := Normalize(contentEncoding); enc {
+	case "":
+		return data, nil
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "deflate":
+		if !isZlib(data) {
+			fr := flate.NewReader(bytes.NewReader(data))
+			defer fr.Close()
+			return io.ReadAll(fr)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+	return io.ReadAll(r)
+}
+
+func Encode(contentEncoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch enc := Normalize(contentEncoding); enc {
+	case "":
+		return data, nil
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func Reencode(contentEncoding string, original, data []byte) ([]byte, error) {
+	if Normalize(contentEncoding) != "deflate" || isZlib(original) {
+		return Encode(contentEncoding, data)
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isZlib(data []byte) bool {
+	return len(data) >= 2 && data[0]&0x0f == 8 && data[0]>>4 <= 7 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0
+}
+
+func IsText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, b := range data {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' && b != '\f' {
+			return false
+		}
+	}
+	return true
+}
+
+func Preview(contentEncoding string, data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	decoded, err := Decode(contentEncoding, data)
+	if err != nil {
+		return fmt.Sprintf("[%s encoded, %d bytes]", Normalize(contentEncoding), len(data))
+	}
+	if !IsText(decoded) {
+		return fmt.Sprintf("[binary, %d bytes]", len(decoded))
+	}
+	return string(decoded)
+}
@@ -0,0 +1,374 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mirage/internal/jsonpath"
+	"mirage/internal/recorder"
+)
+
+const (
+	KindAdded   = "added"
+	KindRemoved = "removed"
+	KindChanged = "changed"
+)
+
+var defaultIgnoredHeaders = []string{
+	"Date",
+	"Age",
+	"Expires",
+	"Last-Modified",
+	"Etag",
+	"Content-Length",
+	"X-Request-Id",
+}
+
+type Options struct {
+	IgnorePaths   []jsonpath.Path
+	IgnoreHeaders map[string]bool
+	IgnoreQuery   map[string]bool
+	Tolerance     float64
+}
+
+func NewOptions(ignorePaths, ignoreHeaders, ignoreQuery []string) (Options, error) {
+	opts := Options{
+		IgnoreHeaders: make(map[string]bool),
+		IgnoreQuery:   make(map[string]bool),
+	}
+	for _, expr := range ignorePaths {
+		p, err := jsonpath.Parse(expr)
+		if err != nil {
+			return opts, err
+		}
+		opts.IgnorePaths = append(opts.IgnorePaths, p)
+	}
+	for _, h := range append(defaultIgnoredHeaders, ignoreHeaders...) {
+		opts.IgnoreHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, q := range ignoreQuery {
+		opts.IgnoreQuery[q] = true
+	}
+	return opts, nil
+}
+
+type Change struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+type StatusChange struct {
+	Before int `json:"before"`
+	After  int `json:"after"`
+}
+
+type Endpoint struct {
+	Key     string        `json:"key"`
+	Status  *StatusChange `json:"status,omitempty"`
+	Headers []Change      `json:"headers,omitempty"`
+	Body    []Change      `json:"body,omitempty"`
+}
+
+type Report struct {
+	Before    string     `json:"before"`
+	After     string     `json:"after"`
+	Added     []string   `json:"added"`
+	Removed   []string   `json:"removed"`
+	Changed   []Endpoint `json:"changed"`
+	Unchanged int        `json:"unchanged"`
+}
+
+func (r *Report) HasChanges() bool {
+	return len(r.Added) > 0 || len(r.Removed) > 0 || len(r.Changed) > 0
+}
+
+func Compare(before, after []recorder.Interaction, opts Options) *Report {
+	report := &Report{
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []Endpoint{},
+	}
+
+	beforeKeys, beforeByKey := index(before, opts)
+	afterKeys, afterByKey := index(after, opts)
+
+	for _, key := range beforeKeys {
+		b := beforeByKey[key]
+		a, ok := afterByKey[key]
+		if !ok {
+			report.Removed = append(report.Removed, key)
+			continue
+		}
+		endpoint := CompareInteraction(key, b.Response, a.Response, opts)
+		if endpoint.Status == nil && len(endpoint.Headers) == 0 && len(endpoint.Body) == 0 {
+			report.Unchanged++
+			continue
+		}
+		report.Changed = append(report.Changed, endpoint)
+	}
+	for _, key := range afterKeys {
+		if _, ok := beforeByKey[key]; !ok {
+			report.Added = append(report.Added, key)
+		}
+	}
+	return report
+}
+
+func CompareInteraction(key string, before, after recorder.RespDetail, opts Options) Endpoint {
+	endpoint := Endpoint{Key: key}
+	if before.Status != after.Status {
+		endpoint.Status = &StatusChange{Before: before.Status, After: after.Status}
+	}
+	endpoint.Headers = CompareHeaders(before.Headers, after.Headers, opts)
+	endpoint.Body = CompareBodies(before, after, opts)
+	return endpoint
+}
+
+func CompareHeaders(before, after map[string][]string, opts Options) []Change {
+	b := canonicalHeaders(before)
+	a := canonicalHeaders(after)
+
+	names := make(map[string]bool)
+	for k := range b {
+		names[k] = true
+	}
+	for k := range a {
+		names[k] = true
+	}
+
+	var changes []Change
+	for _, name := range sortedKeys(names) {
+		if opts.IgnoreHeaders[name] {
+			continue
+		}
+		bv, inBefore := b[name]
+		av, inAfter := a[name]
+		switch {
+		case !inBefore:
+			changes = append(changes, Change{Kind: KindAdded, Path: name, After: av})
+		case !inAfter:
+			changes = append(changes, Change{Kind: KindRemoved, Path: name, Before: bv})
+		case bv != av:
+			changes = append(changes, Change{Kind: KindChanged, Path: name, Before: bv, After: av})
+		}
+	}
+	return changes
+}
+
+func CompareBodies(before, after recorder.RespDetail, opts Options) []Change {
+	bText, bJSON, bOK := bodyValue(before)
+	aText, aJSON, aOK := bodyValue(after)
+
+	if bOK && aOK {
+		return CompareJSON(bJSON, aJSON, opts)
+	}
+	if bText == aText {
+		return nil
+	}
+	return []Change{{Kind: KindChanged, Path: "body", Before: clip(bText), After: clip(aText)}}
+}
+
+func CompareJSON(before, after any, opts Options) []Change {
+	var changes []Change
+	walk(nil, before, after, opts, &changes)
+	return changes
+}
+
+func walk(location []string, before, after any, opts Options, changes *[]Change) {
+	if ignored(location, opts) {
+		return
+	}
+
+	switch b := before.(type) {
+	case map[string]any:
+		a, ok := after.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range b {
+			keys[k] = true
+		}
+		for k := range a {
+			keys[k] = true
+		}
+		for _, k := range sortedKeys(keys) {
+			child := append(append([]string{}, location...), k)
+			bv, inBefore := b[k]
+			av, inAfter := a[k]
+			switch {
+			case !inBefore:
+				if !ignored(child, opts) {
+					*changes = append(*changes, Change{Kind: KindAdded, Path: formatPath(child), After: av})
+				}
+			case !inAfter:
+				if !ignored(child, opts) {
+					*changes = append(*changes, Change{Kind: KindRemoved, Path: formatPath(child), Before: bv})
+				}
+			default:
+				walk(child, bv, av, opts, changes)
+			}
+		}
+		return
+	case []any:
+		a, ok := after.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < len(b) || i < len(a); i++ {
+			child := append(append([]string{}, location...), strconv.Itoa(i))
+			switch {
+			case i >= len(a):
+				if !ignored(child, opts) {
+					*changes = append(*changes, Change{Kind: KindRemoved, Path: formatPath(child), Before: b[i]})
+				}
+			case i >= len(b):
+				if !ignored(child, opts) {
+					*changes = append(*changes, Change{Kind: KindAdded, Path: formatPath(child), After: a[i]})
+				}
+			default:
+				walk(child, b[i], a[i], opts, changes)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) && !withinTolerance(before, after, opts.Tolerance) {
+		*changes = append(*changes, Change{Kind: KindChanged, Path: formatPath(location), Before: before, After: after})
+	}
+}
+
+func withinTolerance(before, after any, tolerance float64) bool {
+	b, ok := before.(json.Number)
+	if !ok {
+		return false
+	}
+	a, ok := after.(json.Number)
+	if !ok {
+		return false
+	}
+	bf, errB := b.Float64()
+	af, errA := a.Float64()
+	if errB != nil || errA != nil {
+		return false
+	}
+	return math.Abs(bf-af) <= tolerance
+}
+
+func ignored(location []string, opts Options) bool {
+	for _, p := range opts.IgnorePaths {
+		if p.Matches(location) {
+			return true
+		}
+	}
+	return false
+}
+
+func RequestKey(i recorder.Interaction, opts Options) string {
+	u, err := url.Parse(i.Request.URL)
+	if err != nil {
+		return i.Request.Method + " " + i.Request.URL
+	}
+
+	key := i.Request.Method + " " + u.Path
+	query := u.Query()
+	for name := range opts.IgnoreQuery {
+		query.Del(name)
+	}
+	if encoded := query.Encode(); encoded != "" {
+		key += "?" + encoded
+	}
+	return key
+}
+
+func index(interactions []recorder.Interaction, opts Options) ([]string, map[string]recorder.Interaction) {
+	keys := make([]string, 0, len(interactions))
+	byKey := make(map[string]recorder.Interaction, len(interactions))
+	seen := make(map[string]int)
+
+	for _, i := range interactions {
+		key := RequestKey(i, opts)
+		seen[key]++
+		if n := seen[key]; n > 1 {
+			key = fmt.Sprintf("%s #%d", key, n)
+		}
+		keys = append(keys, key)
+		byKey[key] = i
+	}
+	return keys, byKey
+}
+
+func bodyValue(d recorder.RespDetail) (string, any, bool) {
+	text := d.Text()
+	if text == "" && d.BodyEncoding != "" {
+		raw, _ := d.RawBody()
+		return fmt.Sprintf("[binary, %d bytes, sha %x]", len(raw), shortHash(raw)), nil, false
+	}
+
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return text, nil, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader([]byte(trimmed)))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return text, nil, false
+	}
+	return text, v, true
+}
+
+func shortHash(data []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(data)
+	return h.Sum32()
+}
+
+func canonicalHeaders(h map[string][]string) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, vv := range h {
+		out[http.CanonicalHeaderKey(k)] = strings.Join(vv, ", ")
+	}
+	return out
+}
+
+func formatPath(location []string) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, part := range location {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		b.WriteString("." + part)
+	}
+	return b.String()
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func clip(s string) string {
+	if len(s) > 200 {
+		return s[:200] + "..."
+	}
+	return s
+}
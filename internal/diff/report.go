@@ -0,0 +1,128 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+)
+
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "", "text":
+		return r.WriteText(w)
+	case "json":
+		return r.WriteJSON(w)
+	case "html":
+		return r.WriteHTML(w)
+	}
+	return fmt.Errorf("unknown format %q (use text, json or html)", format)
+}
+
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n\n", r.Before, r.After)
+
+	for _, key := range r.Removed {
+		fmt.Fprintf(w, "- %s (removed)\n", key)
+	}
+	for _, key := range r.Added {
+		fmt.Fprintf(w, "+ %s (added)\n", key)
+	}
+	if len(r.Added) > 0 || len(r.Removed) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	for _, e := range r.Changed {
+		fmt.Fprintf(w, "~ %s\n", e.Key)
+		if e.Status != nil {
+			fmt.Fprintf(w, "    status: %d -> %d\n", e.Status.Before, e.Status.After)
+		}
+		for _, c := range e.Headers {
+			fmt.Fprintf(w, "    header %s\n", formatChange(c))
+		}
+		for _, c := range e.Body {
+			fmt.Fprintf(w, "    body %s\n", formatChange(c))
+		}
+		fmt.Fprintln(w)
+	}
+
+	_, err := fmt.Fprintf(w, "%d added, %d removed, %d changed, %d unchanged\n",
+		len(r.Added), len(r.Removed), len(r.Changed), r.Unchanged)
+	return err
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlReport.Execute(w, r)
+}
+
+func (c Change) String() string {
+	return formatChange(c)
+}
+
+func formatChange(c Change) string {
+	switch c.Kind {
+	case KindAdded:
+		return fmt.Sprintf("+ %s: %s", c.Path, formatValue(c.After))
+	case KindRemoved:
+		return fmt.Sprintf("- %s: %s", c.Path, formatValue(c.Before))
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Path, formatValue(c.Before), formatValue(c.After))
+}
+
+func formatValue(v any) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return clip(string(data))
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"value": formatValue,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>Mirage diff: {{.Before}} → {{.After}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, 'Inter', 'Segoe UI', sans-serif; color: #171717; max-width: 1100px; margin: 0 auto; padding: 32px; }
+h1 { font-size: 22px; font-weight: 600; }
+h2 { font-size: 15px; margin-top: 32px; }
+.summary { color: #737373; font-size: 14px; }
+.endpoint { border: 1px solid #e5e5e5; border-radius: 8px; padding: 16px; margin-bottom: 12px; }
+.key { font-family: 'SF Mono', monospace; font-size: 13px; font-weight: 600; }
+table { width: 100%; border-collapse: collapse; margin-top: 8px; font-size: 13px; }
+td { padding: 6px 8px; border-top: 1px solid #e5e5e5; font-family: 'SF Mono', monospace; vertical-align: top; word-break: break-all; }
+.added { color: #16a34a; }
+.removed { color: #dc2626; }
+.changed { color: #d97706; }
+</style>
+</head>
+<body>
+<h1>Mirage diff</h1>
+<div class="summary">{{.Before}} → {{.After}} · {{len .Added}} added, {{len .Removed}} removed, {{len .Changed}} changed, {{.Unchanged}} unchanged</div>
+{{if .Removed}}<h2>Removed endpoints</h2>{{range .Removed}}<div class="key removed">- {{.}}</div>{{end}}{{end}}
+{{if .Added}}<h2>Added endpoints</h2>{{range .Added}}<div class="key added">+ {{.}}</div>{{end}}{{end}}
+{{if .Changed}}<h2>Changed endpoints</h2>{{end}}
+{{range .Changed}}
+<div class="endpoint">
+<div class="key">{{.Key}}</div>
+<table>
+{{with .Status}}<tr><td class="changed">status</td><td>{{.Before}}</td><td>{{.After}}</td></tr>{{end}}
+{{range .Headers}}<tr><td class="{{.Kind}}">header {{.Path}}</td><td>{{if ne .Kind "added"}}{{value .Before}}{{end}}</td><td>{{if ne .Kind "removed"}}{{value .After}}{{end}}</td></tr>{{end}}
+{{range .Body}}<tr><td class="{{.Kind}}">{{.Path}}</td><td>{{if ne .Kind "added"}}{{value .Before}}{{end}}</td><td>{{if ne .Kind "removed"}}{{value .After}}{{end}}</td></tr>{{end}}
+</table>
+</div>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,74 @@
+package errclass
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+const (
+	Timeout  = "timeout"
+	Refused  = "refused"
+	Reset    = "reset"
+	DNS      = "dns"
+	TLS      = "tls"
+	Proxy    = "proxy"
+	Canceled = "canceled"
+	Network  = "network"
+	Other    = "other"
+)
+
+func Classify(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	msg := err.Error()
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	case errors.As(err, &dnsErr):
+		return DNS
+	case strings.Contains(msg, "proxyconnect"):
+		return Proxy
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return Timeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return Refused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return Reset
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr), errors.As(err, &invalidCert),
+		strings.Contains(msg, "tls:"), strings.Contains(msg, "x509:"):
+		return TLS
+	case strings.Contains(msg, "connection refused"):
+		return Refused
+	case strings.Contains(msg, "connection reset"), strings.Contains(msg, "broken pipe"), strings.Contains(msg, "EOF"):
+		return Reset
+	case errors.As(err, &opErr):
+		return Network
+	}
+	return Other
+}
+
+func Retryable(kind string) bool {
+	switch kind {
+	case Timeout, Refused, Reset, Network:
+		return true
+	}
+	return false
+}
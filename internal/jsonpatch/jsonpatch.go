@@ -0,0 +1,270 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type Operation struct {
+	Op    string `yaml:"op" json:"op"`
+	Path  string `yaml:"path" json:"path"`
+	From  string `yaml:"from,omitempty" json:"from,omitempty"`
+	Value any    `yaml:"value,omitempty" json:"value,omitempty"`
+}
+
+func Validate(ops []Operation) error {
+	for i, op := range ops {
+		switch op.Op {
+		case "add", "replace", "test", "remove":
+		case "move", "copy":
+			if _, err := parsePointer(op.From); err != nil {
+				return fmt.Errorf("operation %d: from: %w", i, err)
+			}
+		default:
+			return fmt.Errorf("operation %d: unknown op %q", i, op.Op)
+		}
+		if _, err := parsePointer(op.Path); err != nil {
+			return fmt.Errorf("operation %d: path: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func Apply(doc any, ops []Operation) (any, error) {
+	for i, op := range ops {
+		var err error
+		doc, err = apply(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func apply(doc any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		return add(doc, path, normalize(op.Value))
+	case "remove":
+		doc, _, err := remove(doc, path)
+		return doc, err
+	case "replace":
+		if _, err := get(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return normalize(op.Value), nil
+		}
+		doc, _, err := remove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, normalize(op.Value))
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if doc, _, err = remove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+		return add(doc, path, value)
+	case "test":
+		value, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(value, normalize(op.Value)) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+func MergePatch(doc, patch any) any {
+	patch = normalize(patch)
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	target, ok := doc.(map[string]any)
+	if !ok {
+		target = make(map[string]any)
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(target, k)
+			continue
+		}
+		target[k] = MergePatch(target[k], v)
+	}
+	return target
+}
+
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("JSON pointer %q must start with /", ptr)
+	}
+	parts := strings.Split(ptr[1:], "/")
+	for i, p := range parts {
+		parts[i] = strings.ReplaceAll(strings.ReplaceAll(p, "~1", "/"), "~0", "~")
+	}
+	return parts, nil
+}
+
+func get(doc any, path []string) (any, error) {
+	for _, key := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			v, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("path not found: %s", key)
+			}
+			doc = v
+		case []any:
+			i, err := index(key, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("cannot traverse into %T at %q", doc, key)
+		}
+	}
+	return doc, nil
+}
+
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		node[key] = value
+		return doc, nil
+	case []any:
+		i, err := index(key, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return set(doc, path[:len(path)-1], node)
+	}
+	return nil, fmt.Errorf("cannot add to %T", parent)
+}
+
+func remove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	key := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		v, ok := node[key]
+		if !ok {
+			return nil, nil, fmt.Errorf("path not found: %s", key)
+		}
+		delete(node, key)
+		return doc, v, nil
+	case []any:
+		i, err := index(key, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		doc, err = set(doc, path[:len(path)-1], node)
+		return doc, v, err
+	}
+	return nil, nil, fmt.Errorf("cannot remove from %T", parent)
+}
+
+func set(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		node[key] = value
+	case []any:
+		i, err := index(key, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+	default:
+		return nil, fmt.Errorf("cannot set in %T", parent)
+	}
+	return doc, nil
+}
+
+func index(key string, length int, allowEnd bool) (int, error) {
+	if key == "-" && allowEnd {
+		return length, nil
+	}
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || (i > length || (!allowEnd && i == length)) {
+		return 0, fmt.Errorf("invalid array index %q", key)
+	}
+	return i, nil
+}
+
+func normalize(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&out); err != nil {
+		return v
+	}
+	return out
+}
+
+func deepCopy(v any) any {
+	return normalize(v)
+}
+
+func equal(a, b any) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
@@ -0,0 +1,282 @@
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type segment struct {
+	key       string
+	index     int
+	isIndex   bool
+	wildcard  bool
+	recursive bool
+}
+
+type Path struct {
+	raw      string
+	segments []segment
+}
+
+func Parse(expr string) (Path, error) {
+	raw := expr
+	expr = strings.TrimSpace(expr)
+	expr = strings.TrimPrefix(expr, "$")
+	if expr != "" && expr[0] != '.' && expr[0] != '[' {
+		expr = "." + expr
+	}
+
+	var segs []segment
+	for len(expr) > 0 {
+		switch {
+		case strings.HasPrefix(expr, ".."):
+			name, rest := readName(expr[2:])
+			if name == "" {
+				return Path{}, fmt.Errorf("jsonpath %q: expected name after ..", raw)
+			}
+			segs = append(segs, segment{key: name, wildcard: name == "*", recursive: true})
+			expr = rest
+		case expr[0] == '.':
+			name, rest := readName(expr[1:])
+			if name == "" {
+				return Path{}, fmt.Errorf("jsonpath %q: expected name after .", raw)
+			}
+			segs = append(segs, segment{key: name, wildcard: name == "*"})
+			expr = rest
+		case expr[0] == '[':
+			end := strings.IndexByte(expr, ']')
+			if end < 0 {
+				return Path{}, fmt.Errorf("jsonpath %q: unclosed [", raw)
+			}
+			seg, err := parseBracket(expr[1:end])
+			if err != nil {
+				return Path{}, fmt.Errorf("jsonpath %q: %w", raw, err)
+			}
+			segs = append(segs, seg)
+			expr = expr[end+1:]
+		default:
+			return Path{}, fmt.Errorf("jsonpath %q: unexpected %q", raw, expr[0])
+		}
+	}
+
+	if len(segs) == 0 {
+		return Path{}, fmt.Errorf("jsonpath %q: path selects the whole document", raw)
+	}
+	return Path{raw: raw, segments: segs}, nil
+}
+
+func MustParse(expr string) Path {
+	p, err := Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p Path) String() string {
+	return p.raw
+}
+
+func (p Path) Get(doc any) []any {
+	var found []any
+	transform(doc, p.segments, false, func(v any, exists bool) (any, bool) {
+		if exists {
+			found = append(found, v)
+		}
+		return v, exists
+	})
+	return found
+}
+
+func (p Path) Replace(doc any, fn func(any) any) (any, bool) {
+	changed := false
+	doc = transform(doc, p.segments, false, func(v any, exists bool) (any, bool) {
+		if !exists {
+			return v, false
+		}
+		changed = true
+		return fn(v), true
+	})
+	return doc, changed
+}
+
+func (p Path) Set(doc, value any) any {
+	return transform(doc, p.segments, true, func(any, bool) (any, bool) {
+		return value, true
+	})
+}
+
+func (p Path) Delete(doc any) (any, bool) {
+	changed := false
+	doc = transform(doc, p.segments, false, func(v any, exists bool) (any, bool) {
+		if exists {
+			changed = true
+		}
+		return nil, false
+	})
+	return doc, changed
+}
+
+func (p Path) Matches(location []string) bool {
+	return matchLocation(p.segments, location)
+}
+
+func matchLocation(segs []segment, location []string) bool {
+	if len(segs) == 0 {
+		return len(location) == 0
+	}
+	seg := segs[0]
+	if seg.recursive {
+		for i := range location {
+			if seg.matchesKey(location[i]) && matchLocation(segs[1:], location[i+1:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(location) == 0 || !seg.matchesKey(location[0]) {
+		return false
+	}
+	return matchLocation(segs[1:], location[1:])
+}
+
+func (s segment) matchesKey(key string) bool {
+	if s.wildcard {
+		return true
+	}
+	if s.isIndex {
+		return key == strconv.Itoa(s.index)
+	}
+	return key == s.key
+}
+
+func readName(expr string) (string, string) {
+	end := strings.IndexAny(expr, ".[")
+	if end < 0 {
+		return expr, ""
+	}
+	return expr[:end], expr[end:]
+}
+
+func parseBracket(inner string) (segment, error) {
+	inner = strings.TrimSpace(inner)
+	switch {
+	case inner == "*":
+		return segment{wildcard: true}, nil
+	case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+		return segment{key: inner[1 : len(inner)-1]}, nil
+	}
+	idx, err := strconv.Atoi(inner)
+	if err != nil {
+		return segment{}, fmt.Errorf("invalid index %q", inner)
+	}
+	return segment{index: idx, isIndex: true}, nil
+}
+
+type visitor func(v any, exists bool) (any, bool)
+
+func transform(node any, segs []segment, create bool, fn visitor) any {
+	seg := segs[0]
+	last := len(segs) == 1
+
+	if seg.recursive {
+		node = descend(node, segs, fn)
+		seg.recursive = false
+		return transform(node, append([]segment{seg}, segs[1:]...), false, fn)
+	}
+
+	switch n := node.(type) {
+	case map[string]any:
+		if seg.isIndex {
+			return n
+		}
+		keys := []string{seg.key}
+		if seg.wildcard {
+			keys = keys[:0]
+			for k := range n {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			child, exists := n[k]
+			if last {
+				if !exists && !create {
+					continue
+				}
+				v, keep := fn(child, exists)
+				if keep {
+					n[k] = v
+				} else {
+					delete(n, k)
+				}
+				continue
+			}
+			if !exists {
+				if !create {
+					continue
+				}
+				child = map[string]any{}
+			}
+			n[k] = transform(child, segs[1:], create, fn)
+		}
+		return n
+	case []any:
+		if !seg.isIndex && !seg.wildcard {
+			return n
+		}
+		indices := []int{seg.index}
+		if seg.index < 0 {
+			indices[0] = len(n) + seg.index
+		}
+		if seg.wildcard {
+			indices = indices[:0]
+			for i := range n {
+				indices = append(indices, i)
+			}
+		}
+		removed := make(map[int]bool)
+		for _, i := range indices {
+			if i < 0 || i >= len(n) {
+				continue
+			}
+			if last {
+				v, keep := fn(n[i], true)
+				if keep {
+					n[i] = v
+				} else {
+					removed[i] = true
+				}
+				continue
+			}
+			n[i] = transform(n[i], segs[1:], create, fn)
+		}
+		if len(removed) == 0 {
+			return n
+		}
+		kept := make([]any, 0, len(n)-len(removed))
+		for i, v := range n {
+			if !removed[i] {
+				kept = append(kept, v)
+			}
+		}
+		return kept
+	}
+	return node
+}
+
+func descend(node any, segs []segment, fn visitor) any {
+	switch n := node.(type) {
+	case map[string]any:
+		for k, v := range n {
+			n[k] = transform(v, segs, false, fn)
+		}
+		return n
+	case []any:
+		for i, v := range n {
+			n[i] = transform(v, segs, false, fn)
+		}
+		return n
+	}
+	return node
+}
@@ -1,9 +1,14 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
+	"mirage/internal/secrets"
+
 	"github.com/charmbracelet/lipgloss"
 )
 
@@ -31,10 +36,10 @@ var (
 			PaddingRight(1)
 
 	methodStylePUT = lipgloss.NewStyle().
-		Bold(true).
-		Foreground(lipgloss.Color("#f59e0b")).
-		PaddingLeft(1).
-		PaddingRight(1)
+			Bold(true).
+			Foreground(lipgloss.Color("#f59e0b")).
+			PaddingLeft(1).
+			PaddingRight(1)
 
 	methodStyleDELETE = lipgloss.NewStyle().
 				Bold(true).
@@ -57,8 +62,8 @@ var (
 			Foreground(lipgloss.Color("#f59e0b"))
 
 	statusStyleError = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("#ef4444"))
+				Bold(true).
+				Foreground(lipgloss.Color("#ef4444"))
 
 	mockStyle = lipgloss.NewStyle().
 			Bold(true).
@@ -73,7 +78,30 @@ var (
 			Italic(true)
 )
 
+var jsonOutput bool
+
+func SetFormat(format string) {
+	jsonOutput = format == "json"
+}
+
+func emit(level, msg string, fields map[string]any) {
+	entry := map[string]any{
+		"time":  time.Now().Format(time.RFC3339Nano),
+		"level": level,
+		"msg":   msg,
+	}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	data, _ := json.Marshal(entry)
+	fmt.Fprintln(os.Stdout, string(data))
+}
+
 func PrintBanner(version string) {
+	if jsonOutput {
+		emit("info", "mirage "+version, nil)
+		return
+	}
 	banner := lipgloss.NewStyle().
 		Bold(true).
 		Foreground(lipgloss.Color("#00d4ff")).
@@ -94,49 +122,171 @@ func PrintBanner(version string) {
 }
 
 func LogRequest(method, url, body string) {
+	url, body = secrets.Mask(url), secrets.Mask(body)
+	if jsonOutput {
+		emit("info", "request", map[string]any{"method": method, "url": url, "body": body})
+		return
+	}
 	methodStyled := getMethodStyle(method).Render(method)
 	urlStyled := urlStyle.Render(url)
-	
+
 	timestamp := time.Now().Format("15:04:05")
-	fmt.Printf("%s  %s %s\n", 
+	fmt.Printf("%s  %s %s\n",
 		lipgloss.NewStyle().Foreground(lipgloss.Color("#666666")).Render(timestamp),
 		methodStyled,
 		urlStyled)
-	
+
 	if body != "" && len(body) < 200 {
 		fmt.Printf("         %s\n", lipgloss.NewStyle().Foreground(lipgloss.Color("#444444")).Render("→ "+body))
 	}
 }
 
 func LogResponse(status int, duration time.Duration, body string) {
+	body = secrets.Mask(body)
+	if jsonOutput {
+		emit("info", "response", map[string]any{"status": status, "duration_ms": duration.Milliseconds(), "body": body})
+		return
+	}
 	statusStyled := getStatusStyle(status).Render(fmt.Sprintf("%d", status))
 	durationStyled := durationStyle.Render(duration.String())
-	
+
 	fmt.Printf("         %s  %s\n", statusStyled, durationStyled)
-	
+
 	if body != "" && len(body) < 200 {
 		fmt.Printf("         %s\n", lipgloss.NewStyle().Foreground(lipgloss.Color("#444444")).Render("← "+body))
 	}
 }
 
 func LogMock(scenarioName string, status int, duration time.Duration) {
+	if jsonOutput {
+		emit("info", "mock", map[string]any{"scenario": scenarioName, "status": status, "duration_ms": duration.Milliseconds()})
+		return
+	}
 	mockStyled := mockStyle.Render("MOCK")
 	scenarioStyled := lipgloss.NewStyle().Foreground(lipgloss.Color("#00d4ff")).Render(scenarioName)
 	statusStyled := getStatusStyle(status).Render(fmt.Sprintf("%d", status))
 	durationStyled := durationStyle.Render(duration.String())
-	
+
 	fmt.Printf("         %s %s  %s  %s\n", mockStyled, scenarioStyled, statusStyled, durationStyled)
 }
 
+func LogPatch(scenarioName string, status int, duration time.Duration) {
+	if jsonOutput {
+		emit("info", "patch", map[string]any{"scenario": scenarioName, "status": status, "duration_ms": duration.Milliseconds()})
+		return
+	}
+	patchStyled := mockStyle.Render("PATCH")
+	scenarioStyled := lipgloss.NewStyle().Foreground(lipgloss.Color("#00d4ff")).Render(scenarioName)
+	statusStyled := getStatusStyle(status).Render(fmt.Sprintf("%d", status))
+	durationStyled := durationStyle.Render(duration.String())
+
+	fmt.Printf("         %s %s  %s  %s\n", patchStyled, scenarioStyled, statusStyled, durationStyled)
+}
+
+func LogFault(route string, status int, duration time.Duration) {
+	if jsonOutput {
+		emit("info", "fault", map[string]any{"route": route, "status": status, "duration_ms": duration.Milliseconds()})
+		return
+	}
+	faultStyled := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#ef4444")).PaddingLeft(1).Render("FAULT")
+	routeStyled := lipgloss.NewStyle().Foreground(lipgloss.Color("#00d4ff")).Render(route)
+	statusStyled := getStatusStyle(status).Render(fmt.Sprintf("%d", status))
+	durationStyled := durationStyle.Render(duration.String())
+
+	fmt.Printf("         %s %s  %s  %s\n", faultStyled, routeStyled, statusStyled, durationStyled)
+}
+
+func LogPlayback(cassette string, status int, duration time.Duration) {
+	if jsonOutput {
+		emit("info", "playback", map[string]any{"cassette": cassette, "status": status, "duration_ms": duration.Milliseconds()})
+		return
+	}
+
+	tapeStyled := mockStyle.Render("TAPE")
+	cassetteStyled := lipgloss.NewStyle().Foreground(lipgloss.Color("#00d4ff")).Render(cassette)
+	statusStyled := getStatusStyle(status).Render(fmt.Sprintf("%d", status))
+	durationStyled := durationStyle.Render(duration.String())
+
+	fmt.Printf("         %s %s  %s  %s\n", tapeStyled, cassetteStyled, statusStyled, durationStyled)
+}
+
+func LogTunnel(target string, sent, received int64, duration time.Duration, err error) {
+	if jsonOutput {
+		fields := map[string]any{"target": target, "bytes_sent": sent, "bytes_received": received, "duration_ms": duration.Milliseconds()}
+		if err != nil {
+			fields["error"] = secrets.Mask(err.Error())
+		}
+		emit("info", "tunnel", fields)
+		return
+	}
+
+	timestamp := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666")).Render(time.Now().Format("15:04:05"))
+	tunnelStyled := mockStyle.Render("RAW")
+	targetStyled := urlStyle.Render("tcp://" + target)
+	fmt.Printf("%s  %s %s\n", timestamp, tunnelStyled, targetStyled)
+
+	if err != nil {
+		errStyled := lipgloss.NewStyle().Foreground(lipgloss.Color("#ef4444")).Render(secrets.Mask(err.Error()))
+		fmt.Printf("         %s\n", errStyled)
+		return
+	}
+	bytesStyled := durationStyle.Render(fmt.Sprintf("↑ %d B  ↓ %d B", sent, received))
+	fmt.Printf("         %s  %s\n", bytesStyled, durationStyle.Render(duration.String()))
+}
+
+func LogRewrite(rules []string) {
+	if jsonOutput {
+		emit("info", "rewrite", map[string]any{"rules": rules})
+		return
+	}
+
+	rewriteStyled := lipgloss.NewStyle().Foreground(lipgloss.Color("#f59e0b")).Render("EDIT")
+	fmt.Printf("         %s %s\n", rewriteStyled, durationStyle.Render(strings.Join(rules, ", ")))
+}
+
+func LogSkip(reason string) {
+	if jsonOutput {
+		emit("info", "skip", map[string]any{"reason": reason})
+		return
+	}
+
+	skipStyled := lipgloss.NewStyle().Foreground(lipgloss.Color("#888888")).Render("SKIP")
+	fmt.Printf("         %s %s\n", skipStyled, durationStyle.Render("not recorded: "+reason))
+}
+
 func LogInfo(message string) {
+	message = secrets.Mask(message)
+	if jsonOutput {
+		emit("info", message, nil)
+		return
+	}
 	fmt.Println(lipgloss.NewStyle().Foreground(lipgloss.Color("#00d4ff")).Render("ℹ " + message))
 }
 
 func LogSuccess(message string) {
+	message = secrets.Mask(message)
+	if jsonOutput {
+		emit("info", message, nil)
+		return
+	}
 	fmt.Println(lipgloss.NewStyle().Foreground(lipgloss.Color("#10b981")).Render("✓ " + message))
 }
 
+func LogWarning(message string) {
+	message = secrets.Mask(message)
+	if jsonOutput {
+		emit("warn", message, nil)
+		return
+	}
+	fmt.Println(lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#f59e0b")).Render("⚠ " + message))
+}
+
 func LogError(message string) {
+	message = secrets.Mask(message)
+	if jsonOutput {
+		emit("error", message, nil)
+		return
+	}
 	fmt.Println(lipgloss.NewStyle().Foreground(lipgloss.Color("#ef4444")).Render("✗ " + message))
 }
 
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"mirage/internal/config"
+)
+
+type listenerScope struct {
+	name      string
+	route     *route
+	scenarios map[string]bool
+}
+
+type listenerKey struct{}
+
+func (p *Proxy) ListenerHandler(l config.Listener) (http.Handler, error) {
+	scope := &listenerScope{name: l.Name}
+	if scope.name == "" {
+		scope.name = l.Address
+	}
+	if l.Route != "" {
+		for _, rt := range p.routes {
+			if rt.cfg.Name == l.Route {
+				scope.route = rt
+			}
+		}
+		if scope.route == nil {
+			return nil, fmt.Errorf("listener %q: unknown route %q", scope.name, l.Route)
+		}
+	}
+	if len(l.Scenarios) > 0 {
+		scope.scenarios = make(map[string]bool, len(l.Scenarios))
+		for _, name := range l.Scenarios {
+			scope.scenarios[name] = true
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), listenerKey{}, scope)))
+	}), nil
+}
+
+func listenerOf(r *http.Request) *listenerScope {
+	scope, _ := r.Context().Value(listenerKey{}).(*listenerScope)
+	return scope
+}
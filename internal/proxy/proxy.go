@@ -2,147 +2,399 @@ package proxy
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"mirage/internal/cassette"
 	"mirage/internal/config"
+	"mirage/internal/content"
+	"mirage/internal/errclass"
 	"mirage/internal/logger"
 	"mirage/internal/recorder"
+	"mirage/internal/redact"
+	"mirage/internal/rewrite"
 	"mirage/internal/scenario"
+	"mirage/internal/timing"
 )
 
+const HeaderError = "X-Mirage-Error"
+
 type Proxy struct {
-	client   *http.Client
-	matcher  *scenario.Matcher
-	recorder *recorder.Recorder
-	
+	client    *http.Client
+	transport *http.Transport
+	upstream  config.Upstream
+	matcher   *scenario.Matcher
+	recMu     sync.RWMutex
+	recorder  *recorder.Recorder
+	recording bool
+	recordCfg config.Recording
+
+	redactor *redact.Redactor
+	tapes    *cassette.Manager
+	rewrites *rewrite.Engine
+	routes   []*route
+
 	reqLogMu   sync.RWMutex
 	reqLog     []LogEntry
 	MaxLogSize int
+
+	handled  atomic.Int64
+	failed   atomic.Int64
+	inFlight atomic.Int64
 }
 
 type LogEntry struct {
-	ID        int64         `json:"id"`
-	Timestamp time.Time     `json:"timestamp"`
-	Method    string        `json:"method"`
-	URL       string        `json:"url"`
-	Status    int           `json:"status"`
-	Duration  time.Duration `json:"duration"`
-	Matched   string        `json:"matched,omitempty"`
+	ID        int64          `json:"id"`
+	Timestamp time.Time      `json:"timestamp"`
+	Method    string         `json:"method"`
+	URL       string         `json:"url"`
+	Status    int            `json:"status"`
+	Duration  time.Duration  `json:"duration"`
+	Matched   string         `json:"matched,omitempty"`
+	Route     string         `json:"route,omitempty"`
+	Listener  string         `json:"listener,omitempty"`
+	Timings   *timing.Phases `json:"timings,omitempty"`
+	Rewrites  []string       `json:"rewrites,omitempty"`
+	Sent      int64          `json:"bytes_sent,omitempty"`
+	Received  int64          `json:"bytes_received,omitempty"`
+	Attempts  int            `json:"attempts,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	ErrorKind string         `json:"error_kind,omitempty"`
 }
 
-func NewProxy(cfg *config.Config, rec *recorder.Recorder) *Proxy {
+func NewProxy(cfg *config.Config) (*Proxy, error) {
+	if cfg == nil {
+		cfg = config.Default()
+	}
+
+	red, err := redact.New(cfg.Redact)
+	if err != nil {
+		return nil, err
+	}
+	tapes, err := cassette.NewManager(cfg.Cassettes, red)
+	if err != nil {
+		return nil, err
+	}
+
+	rewrites, err := rewrite.New(cfg.Rewrites)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newTransport(cfg.Server.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	roundTripper, err := newUpstreamTransport(transport, cfg.Server.Upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	recordCfg := newRecordingConfig(cfg.Server.Recording)
+	routes, err := newRoutes(cfg.Routes, recordCfg)
+	if err != nil {
+		return nil, err
+	}
+
 	var m *scenario.Matcher
-	if cfg != nil {
+	if len(cfg.Scenarios) > 0 {
 		m = scenario.NewMatcher(cfg.Scenarios)
 	}
 
 	return &Proxy{
-		client: &http.Client{
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				return http.ErrUseLastResponse
-			},
-		},
+		client:     newClient(cfg.Server.Upstream, roundTripper),
+		transport:  transport,
+		upstream:   cfg.Server.Upstream,
 		matcher:    m,
-		recorder:   rec,
+		recordCfg:  recordCfg,
+		redactor:   red,
+		tapes:      tapes,
+		rewrites:   rewrites,
+		routes:     routes,
 		reqLog:     make([]LogEntry, 0),
 		MaxLogSize: 100,
-	}
+	}, nil
 }
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
+	p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
 
 	var reqBody []byte
 	if r.Body != nil {
 		reqBody, _ = io.ReadAll(r.Body)
 		r.Body = io.NopCloser(bytes.NewBuffer(reqBody))
 	}
-	
-	logReqBody := string(reqBody)
-	if len(logReqBody) > 500 {
-		logReqBody = logReqBody[:500] + "..."
+
+	scope := listenerOf(r)
+	rt := p.routeFor(r)
+	if scope != nil && scope.route != nil {
+		rt = scope.route
+	}
+	incoming := r
+	if rt != nil {
+		r = rt.target(r)
 	}
 
-	logger.LogRequest(r.Method, r.URL.String(), logReqBody)
-	
-	var matchedScenario string
-	var status int
-	
-	if p.matcher != nil {
-		if s := p.matcher.Match(r); s != nil {
-			scenario.ServeMock(w, s)
-			
-			duration := time.Since(start)
-			
-			matchedScenario = s.Name
-			status = s.Response.Status
-			if status == 0 { status = 200 }
-			
-			logger.LogMock(s.Name, status, duration)
-			p.logRequest(r, status, duration, matchedScenario)
+	logReqBody := truncate(p.redactor.Body(r.Header.Get("Content-Type"), content.Preview(r.Header.Get("Content-Encoding"), reqBody)))
+	logger.LogRequest(r.Method, p.redactor.URL(r.URL.String()), logReqBody)
+
+	if s := p.matchScenario(incoming, r, rt, scope); s != nil {
+		if s.Response.Patches() {
+			p.servePatched(w, r, s, reqBody, start)
+		} else {
+			p.serveMock(w, r, s, reqBody, start)
+		}
+		return
+	}
+
+	tape, err := p.tapes.Resolve(r)
+	if err != nil {
+		logger.LogError("Cassette: " + err.Error())
+		http.Error(w, "mirage: "+err.Error(), http.StatusInternalServerError)
+		p.logRequest(r, LogEntry{Status: http.StatusInternalServerError, Duration: time.Since(start)})
+		return
+	}
+	if tape != nil {
+		if i := tape.Find(r, reqBody); i != nil {
+			p.playback(w, r, tape, i, reqBody, start)
+			return
+		}
+		if !tape.CanRecord() {
+			msg := fmt.Sprintf("no interaction in cassette %q matches %s %s (mode %s)", tape.Name, r.Method, r.URL.String(), tape.Mode)
+			logger.LogError(msg)
+			w.Header().Set("X-Mirage-Cassette-Miss", "true")
+			http.Error(w, "mirage: "+msg, http.StatusInternalServerError)
+			p.logRequest(r, LogEntry{Status: http.StatusInternalServerError, Duration: time.Since(start), Matched: "cassette:" + tape.Name})
 			return
 		}
 	}
 
+	if rt != nil && p.injectFault(w, r, rt, start) {
+		return
+	}
+
+	up, ok := p.forward(w, r, reqBody, start)
+	if !ok {
+		return
+	}
+	writeResponse(w, up.resp, up.body)
+
+	duration := time.Since(start)
+	logRespBody := truncate(p.redactor.Body(up.resp.Header.Get("Content-Type"), content.Preview(up.resp.Header.Get("Content-Encoding"), up.body)))
+
+	logger.LogResponse(up.resp.StatusCode, duration, logRespBody)
+
+	p.record(up.req, up.reqBody, up.resp, up.body, duration, recorder.Meta{Source: recorder.SourceProxy, Timings: up.timings})
+
+	if tape != nil {
+		interaction := recorder.NewInteraction(r, reqBody, up.resp, up.body, duration, p.redactor)
+		interaction.Timings = up.timings
+		if err := tape.Add(interaction); err != nil {
+			logger.LogError("Cassette recording failed: " + err.Error())
+		}
+	}
+
+	p.logRequest(r, LogEntry{Status: up.resp.StatusCode, Duration: duration, Timings: up.timings, Rewrites: up.rewrites.Names(), Attempts: up.attempts})
+}
+
+type upstreamResponse struct {
+	req      *http.Request
+	reqBody  []byte
+	resp     *http.Response
+	body     []byte
+	timings  *timing.Phases
+	rewrites rewrite.Applied
+	attempts int
+}
+
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, reqBody []byte, start time.Time) (*upstreamResponse, bool) {
 	outReq := r.Clone(r.Context())
-	
+	outReq.RequestURI = ""
+
 	delHopHeaders(outReq.Header)
+	outReq.Header.Del(cassette.HeaderName)
+	outReq.Header.Del(cassette.HeaderMode)
 
-	resp, err := p.client.Do(outReq)
-	if err != nil {
-		logger.LogError("Forwarding failed: " + err.Error())
-		http.Error(w, "Error forwarding request: "+err.Error(), http.StatusBadGateway)
-		p.logRequest(r, 502, time.Since(start), "")
-		return
+	body := reqBody
+	applied := p.rewrites.Match(r)
+	if len(applied) > 0 {
+		body = applied.Request(outReq, reqBody)
+		logger.LogRewrite(applied.Names())
 	}
+
+	result := p.roundTrip(outReq, body)
+	defer result.cancel()
+	tracer := result.tracer
+	if result.err != nil {
+		p.upstreamError(w, r, outReq, result.err, result.attempts, tracer.Phases(), start)
+		return nil, false
+	}
+	resp := result.resp
 	defer resp.Body.Close()
 
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		p.upstreamError(w, r, outReq, fmt.Errorf("reading response body: %w", err), result.attempts, tracer.Phases(), start)
+		return nil, false
+	}
+	tracer.Done()
+
+	if len(applied) > 0 {
+		respBody = applied.Response(resp, respBody)
+	}
 	delHopHeaders(resp.Header)
-	copyHeader(w.Header(), resp.Header)
 
+	return &upstreamResponse{req: outReq, reqBody: body, resp: resp, body: respBody, timings: tracer.Phases(), rewrites: applied, attempts: result.attempts}, true
+}
+
+func (p *Proxy) upstreamError(w http.ResponseWriter, r, outReq *http.Request, err error, attempts int, timings *timing.Phases, start time.Time) {
+	kind := errclass.Classify(err)
+	detail := err.Error()
+	if tlsDetail := describeTLSError(err); tlsDetail != "" {
+		detail = tlsDetail + " (" + outReq.URL.Host + ")"
+	}
+
+	summary := kind
+	if attempts > 1 {
+		summary = fmt.Sprintf("%s after %d attempts", kind, attempts)
+	}
+	if via := describeProxy(p.transport, outReq); via != "" {
+		logger.LogError(fmt.Sprintf("Forwarding failed via upstream proxy %s (%s): %s", via, summary, detail))
+	} else {
+		logger.LogError(fmt.Sprintf("Forwarding failed (%s): %s", summary, detail))
+	}
+
+	status := errorStatus(kind)
+	w.Header().Set(HeaderError, kind)
+	http.Error(w, fmt.Sprintf("mirage: upstream %s error: %s", kind, detail), status)
+	p.logRequest(r, LogEntry{Status: status, Duration: time.Since(start), Timings: timings, Attempts: attempts, Error: detail, ErrorKind: kind})
+}
+
+func writeResponse(w http.ResponseWriter, resp *http.Response, body []byte) {
+	copyHeader(w.Header(), resp.Header)
 	w.WriteHeader(resp.StatusCode)
-	status = resp.StatusCode
+	w.Write(body)
+}
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.LogError("Reading response body: " + err.Error())
+func (p *Proxy) servePatched(w http.ResponseWriter, r *http.Request, s *config.Scenario, reqBody []byte, start time.Time) {
+	up, ok := p.forward(w, r, reqBody, start)
+	if !ok {
 		return
 	}
-	
-	w.Write(respBody)
+
+	original := &http.Response{StatusCode: up.resp.StatusCode, Header: up.resp.Header.Clone()}
+	originalBody := up.body
+
+	body, err := scenario.PatchResponse(r.Context(), up.resp, up.body, s)
+	if err != nil {
+		logger.LogError(fmt.Sprintf("Scenario %s: patch failed, serving the upstream response: %v", s.Name, err))
+	}
+	writeResponse(w, up.resp, body)
+
+	duration := time.Since(start)
+	logger.LogPatch(s.Name, up.resp.StatusCode, duration)
+
+	p.record(up.req, up.reqBody, up.resp, body, duration, recorder.Meta{
+		Source:       recorder.SourcePatch,
+		Scenario:     s.Name,
+		Timings:      up.timings,
+		Original:     original,
+		OriginalBody: originalBody,
+	})
+	p.logRequest(r, LogEntry{Status: up.resp.StatusCode, Duration: duration, Matched: s.Name, Timings: up.timings, Rewrites: up.rewrites.Names(), Attempts: up.attempts})
+}
+
+func (p *Proxy) serveMock(w http.ResponseWriter, r *http.Request, s *config.Scenario, reqBody []byte, start time.Time) {
+	cw := &captureWriter{ResponseWriter: w}
+	scenario.ServeMock(cw, s)
 
 	duration := time.Since(start)
-	logRespBody := string(respBody)
-	if len(logRespBody) > 500 {
-		logRespBody = logRespBody[:500] + "..."
+	resp := cw.response()
+
+	logger.LogMock(s.Name, resp.StatusCode, duration)
+	p.record(r, reqBody, resp, cw.body.Bytes(), duration, recorder.Meta{Source: recorder.SourceMock, Scenario: s.Name})
+	p.logRequest(r, LogEntry{Status: resp.StatusCode, Duration: duration, Matched: s.Name})
+}
+
+func (p *Proxy) playback(w http.ResponseWriter, r *http.Request, tape *cassette.Cassette, i *recorder.Interaction, reqBody []byte, start time.Time) {
+	cw := &captureWriter{ResponseWriter: w}
+	if _, err := cassette.Play(cw, i); err != nil {
+		logger.LogError(fmt.Sprintf("Cassette %s playback failed: %v", tape.Name, err))
 	}
-	
-	logger.LogResponse(resp.StatusCode, duration, logRespBody)
 
-	if p.recorder != nil {
-		p.recorder.Record(r, string(reqBody), resp, string(respBody), duration)
+	duration := time.Since(start)
+	resp := cw.response()
+
+	logger.LogPlayback(tape.Name, resp.StatusCode, duration)
+	p.record(r, reqBody, resp, cw.body.Bytes(), duration, recorder.Meta{Source: recorder.SourceCassette})
+	p.logRequest(r, LogEntry{Status: resp.StatusCode, Duration: duration, Matched: "cassette:" + tape.Name})
+}
+
+func (p *Proxy) matchScenario(incoming, upstream *http.Request, rt *route, scope *listenerScope) *config.Scenario {
+	if rt != nil && rt.matcher != nil {
+		if s := rt.matcher.Match(upstream); s != nil {
+			return s
+		}
 	}
-	
-	p.logRequest(r, status, duration, "")
+	if p.matcher == nil {
+		return nil
+	}
+	if scope != nil && scope.scenarios != nil {
+		return p.matcher.MatchOnly(incoming, scope.scenarios)
+	}
+	return p.matcher.Match(incoming)
+}
+
+func (p *Proxy) Cassettes() *cassette.Manager {
+	return p.tapes
 }
 
-func (p *Proxy) logRequest(r *http.Request, status int, duration time.Duration, matched string) {
+func (p *Proxy) logRequest(r *http.Request, entry LogEntry) {
+	entry.Method = r.Method
+	entry.URL = p.redactor.URL(r.URL.String())
+	if rt := routeOf(r); rt != nil {
+		entry.Route = rt.cfg.Name
+	}
+	if scope := listenerOf(r); scope != nil {
+		entry.Listener = scope.name
+	}
+	p.appendLog(entry)
+}
+
+func (p *Proxy) LogTunnel(target string, sent, received int64, duration time.Duration, err error) {
+	logger.LogTunnel(target, sent, received, duration, err)
+
+	entry := LogEntry{
+		Method:   "TUNNEL",
+		URL:      "tcp://" + target,
+		Duration: duration,
+		Matched:  "socks",
+		Sent:     sent,
+		Received: received,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	p.appendLog(entry)
+}
+
+func (p *Proxy) appendLog(entry LogEntry) {
+	p.handled.Add(1)
+	if entry.Error != "" {
+		p.failed.Add(1)
+	}
+
 	p.reqLogMu.Lock()
 	defer p.reqLogMu.Unlock()
-	
-	entry := LogEntry{
-		ID:        time.Now().UnixNano(),
-		Timestamp: time.Now(),
-		Method:    r.Method,
-		URL:       r.URL.String(),
-		Status:    status,
-		Duration:  duration,
-		Matched:   matched,
-	}
-	
+
+	entry.ID = time.Now().UnixNano()
+	entry.Timestamp = time.Now()
+
 	p.reqLog = append(p.reqLog, entry)
 	if len(p.reqLog) > p.MaxLogSize {
 		p.reqLog = p.reqLog[1:]
@@ -158,15 +410,53 @@ func (p *Proxy) GetRecentRequests() []LogEntry {
 }
 
 func (p *Proxy) GetScenarios() []scenario.RuntimeScenario {
-	if p.matcher == nil {
-		return nil
+	var res []scenario.RuntimeScenario
+	for _, m := range p.matchers() {
+		res = append(res, m.GetScenarios()...)
 	}
-	return p.matcher.GetScenarios()
+	return res
 }
 
-func (p *Proxy) ToggleScenario(name string, enabled bool) bool {
-	if p.matcher == nil { return false }
-	return p.matcher.SetEnabled(name, enabled)
+func (p *Proxy) ToggleScenario(routeName, name string, enabled bool) bool {
+	if routeName == "" {
+		return p.matcher != nil && p.matcher.SetEnabled(name, enabled)
+	}
+	for _, rt := range p.routes {
+		if rt.cfg.Name == routeName {
+			return rt.matcher != nil && rt.matcher.SetEnabled(name, enabled)
+		}
+	}
+	return false
+}
+
+func (p *Proxy) matchers() []*scenario.Matcher {
+	var res []*scenario.Matcher
+	if p.matcher != nil {
+		res = append(res, p.matcher)
+	}
+	for _, rt := range p.routes {
+		if rt.matcher != nil {
+			res = append(res, rt.matcher)
+		}
+	}
+	return res
+}
+
+func truncate(body string) string {
+	if len(body) > 500 {
+		return body[:500] + "..."
+	}
+	return body
+}
+
+func newClient(upstream config.Upstream, transport http.RoundTripper) *http.Client {
+	client := &http.Client{Transport: transport}
+	if !upstream.FollowRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return client
 }
 
 func copyHeader(dst, src http.Header) {
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"mirage/internal/config"
+	"mirage/internal/logger"
+	"mirage/internal/recorder"
+)
+
+type RecordingStatus struct {
+	Enabled bool   `json:"enabled"`
+	Output  string `json:"output"`
+	Count   int    `json:"count"`
+}
+
+func (p *Proxy) SetRecording(enabled bool) error {
+	p.recMu.Lock()
+	defer p.recMu.Unlock()
+
+	if enabled && p.recorder == nil {
+		rec, err := recorder.NewRecorder(p.recordCfg)
+		if err != nil {
+			return err
+		}
+		rec.SetRedactor(p.redactor)
+		p.recorder = rec
+	}
+	if enabled {
+		for _, rt := range p.routes {
+			if rt.recordCfg.Output == "" || rt.recorder != nil {
+				continue
+			}
+			rec, err := recorder.NewRecorder(rt.recordCfg)
+			if err != nil {
+				return fmt.Errorf("route %q: %w", rt.cfg.Name, err)
+			}
+			rec.SetRedactor(p.redactor)
+			rt.recorder = rec
+		}
+	}
+	p.recording = enabled
+	return nil
+}
+
+func (p *Proxy) Recording() RecordingStatus {
+	p.recMu.RLock()
+	defer p.recMu.RUnlock()
+
+	status := RecordingStatus{Enabled: p.recording, Output: p.recordCfg.Output}
+	if p.recorder != nil {
+		status.Count = p.recorder.Count()
+	}
+	return status
+}
+
+func (p *Proxy) activeRecorder(rt *route) *recorder.Recorder {
+	if !p.recording {
+		return nil
+	}
+	if rt != nil && rt.recorder != nil {
+		return rt.recorder
+	}
+	return p.recorder
+}
+
+func (p *Proxy) record(r *http.Request, reqBody []byte, resp *http.Response, respBody []byte, duration time.Duration, meta recorder.Meta) {
+	p.recMu.RLock()
+	defer p.recMu.RUnlock()
+
+	rec := p.activeRecorder(routeOf(r))
+	if rec == nil {
+		return
+	}
+
+	if ok, reason := rec.Allow(r, resp.StatusCode, resp.Header.Get("Content-Type")); !ok {
+		logger.LogSkip(reason)
+		return
+	}
+	if err := rec.Record(r, reqBody, resp, respBody, duration, meta); err != nil {
+		logger.LogError("Recording failed: " + err.Error())
+	}
+}
+
+func (p *Proxy) Close() error {
+	p.recMu.Lock()
+	defer p.recMu.Unlock()
+
+	var errs []error
+	for _, rt := range p.routes {
+		if rt.recorder != nil {
+			errs = append(errs, rt.recorder.Close())
+			rt.recorder = nil
+		}
+	}
+	if p.recorder != nil {
+		errs = append(errs, p.recorder.Close())
+		p.recorder = nil
+	}
+	p.recording = false
+	errs = append(errs, p.tapes.Close())
+	return errors.Join(errs...)
+}
+
+type Summary struct {
+	Requests int64
+	Failed   int64
+	InFlight int64
+	Recorded []RecordedFile
+}
+
+type RecordedFile struct {
+	Output string
+	Count  int
+}
+
+func (p *Proxy) Summary() Summary {
+	p.recMu.RLock()
+	defer p.recMu.RUnlock()
+
+	summary := Summary{Requests: p.handled.Load(), Failed: p.failed.Load(), InFlight: p.inFlight.Load()}
+	if p.recorder != nil {
+		summary.Recorded = append(summary.Recorded, RecordedFile{Output: p.recordCfg.Output, Count: p.recorder.Count()})
+	}
+	for _, rt := range p.routes {
+		if rt.recorder != nil {
+			summary.Recorded = append(summary.Recorded, RecordedFile{Output: rt.recordCfg.Output, Count: rt.recorder.Count()})
+		}
+	}
+	return summary
+}
+
+func newRecordingConfig(cfg config.Recording) config.Recording {
+	if cfg.Output == "" {
+		cfg.Output = config.DefaultOutput
+	}
+	return cfg
+}
+
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *captureWriter) response() *http.Response {
+	status := c.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &http.Response{StatusCode: status, Header: c.Header().Clone()}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"time"
+
+	"mirage/internal/config"
+	"mirage/internal/errclass"
+	"mirage/internal/logger"
+	"mirage/internal/timing"
+)
+
+type attemptResult struct {
+	resp     *http.Response
+	tracer   *timing.Tracer
+	attempts int
+	err      error
+	cancel   context.CancelFunc
+}
+
+func (p *Proxy) roundTrip(req *http.Request, body []byte) attemptResult {
+	policy := p.upstream.Retry
+	maxAttempts := 1
+	if policy.Attempts > 0 && idempotent(req) {
+		maxAttempts = policy.Attempts + 1
+	}
+
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if p.upstream.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.upstream.Timeout)
+	}
+
+	var result attemptResult
+	for attempt := 1; ; attempt++ {
+		tracer := timing.NewTracer()
+		out := req.WithContext(httptrace.WithClientTrace(ctx, tracer.Trace()))
+		out.Body, out.ContentLength = http.NoBody, 0
+		if len(body) > 0 {
+			out.Body = io.NopCloser(bytes.NewReader(body))
+			out.ContentLength = int64(len(body))
+		}
+
+		resp, err := p.client.Do(out)
+		result = attemptResult{resp: resp, tracer: tracer, attempts: attempt, err: err, cancel: cancel}
+		if attempt >= maxAttempts || !shouldRetry(policy, resp, err) {
+			return result
+		}
+		wait := backoff(policy, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			return result
+		}
+		reason := errclass.Classify(err)
+		if resp != nil {
+			reason = resp.Status
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		logger.LogWarning(fmt.Sprintf("Retrying %s %s in %s (attempt %d/%d): %s", req.Method, req.URL, wait.Round(time.Millisecond), attempt+1, maxAttempts, reason))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			result.resp, result.err = nil, ctx.Err()
+			return result
+		}
+	}
+}
+
+func shouldRetry(policy config.Retry, resp *http.Response, err error) bool {
+	if err != nil {
+		return errclass.Retryable(errclass.Classify(err))
+	}
+	return slices.Contains(policy.Statuses, resp.StatusCode)
+}
+
+func backoff(policy config.Retry, attempt int) time.Duration {
+	d := policy.Backoff << (attempt - 1)
+	if d <= 0 || d > policy.MaxBackoff {
+		d = policy.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+func errorStatus(kind string) int {
+	if kind == errclass.Timeout {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
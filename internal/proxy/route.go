@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"mirage/internal/config"
+	"mirage/internal/logger"
+	"mirage/internal/recorder"
+	"mirage/internal/scenario"
+)
+
+const HeaderFault = "X-Mirage-Fault"
+
+type route struct {
+	cfg       config.Route
+	base      *url.URL
+	matcher   *scenario.Matcher
+	recordCfg config.Recording
+	recorder  *recorder.Recorder
+}
+
+type routeKey struct{}
+
+func newRoutes(routes []config.Route, rec config.Recording) ([]*route, error) {
+	out := make([]*route, 0, len(routes))
+	for _, cfg := range routes {
+		base, err := url.Parse(cfg.Upstream)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", cfg.Name, err)
+		}
+
+		rt := &route{cfg: cfg, base: base}
+		if len(cfg.Scenarios) > 0 {
+			rt.matcher = scenario.NewMatcher(cfg.Scenarios)
+			for _, s := range rt.matcher.Scenarios {
+				s.Route = cfg.Name
+			}
+		}
+		if cfg.Recording.Output != "" {
+			rt.recordCfg = rec
+			rt.recordCfg.Output = cfg.Recording.Output
+		}
+		out = append(out, rt)
+	}
+	return out, nil
+}
+
+func (p *Proxy) routeFor(r *http.Request) *route {
+	for _, rt := range p.routes {
+		if rt.matches(r) {
+			return rt
+		}
+	}
+	return nil
+}
+
+func (rt *route) matches(r *http.Request) bool {
+	if rt.cfg.Match.Host != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if ok, _ := path.Match(strings.ToLower(rt.cfg.Match.Host), strings.ToLower(host)); !ok {
+			return false
+		}
+	}
+	return rt.cfg.Match.PathPrefix == "" || hasPathPrefix(r.URL.Path, rt.cfg.Match.PathPrefix)
+}
+
+func (rt *route) target(r *http.Request) *http.Request {
+	r = r.WithContext(context.WithValue(r.Context(), routeKey{}, rt))
+
+	path := r.URL.Path
+	if rt.cfg.StripPrefix {
+		path = strings.TrimPrefix(path, strings.TrimSuffix(rt.cfg.Match.PathPrefix, "/"))
+	}
+
+	u := *r.URL
+	u.Scheme = rt.base.Scheme
+	u.Host = rt.base.Host
+	u.Path = joinPath(rt.base.Path, path)
+	u.RawPath = ""
+	r.URL = &u
+	r.Host = rt.base.Host
+	return r
+}
+
+func routeOf(r *http.Request) *route {
+	rt, _ := r.Context().Value(routeKey{}).(*route)
+	return rt
+}
+
+func (p *Proxy) injectFault(w http.ResponseWriter, r *http.Request, rt *route, start time.Time) bool {
+	faults := rt.cfg.Faults
+	if delay := faults.Delay + jitter(faults.Jitter); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+	}
+	if faults.ErrorRate <= 0 || rand.Float64() >= faults.ErrorRate {
+		return false
+	}
+
+	w.Header().Set(HeaderFault, "error")
+	http.Error(w, fmt.Sprintf("mirage: fault injected by route %q", rt.cfg.Name), faults.ErrorStatus)
+
+	duration := time.Since(start)
+	logger.LogFault(rt.cfg.Name, faults.ErrorStatus, duration)
+	p.logRequest(r, LogEntry{Status: faults.ErrorStatus, Duration: duration, Matched: "fault"})
+	return true
+}
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max + 1)
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func joinPath(base, path string) string {
+	if path == "" {
+		path = "/"
+		if base != "" {
+			return base
+		}
+	}
+	if base == "" || base == "/" {
+		return path
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+func (p *Proxy) Routes() []config.Route {
+	out := make([]config.Route, len(p.routes))
+	for i, rt := range p.routes {
+		out[i] = rt.cfg
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"mirage/internal/config"
+	"mirage/internal/logger"
+)
+
+type hostTransport struct {
+	base  http.RoundTripper
+	hosts []hostRoute
+}
+
+type hostRoute struct {
+	match     string
+	transport http.RoundTripper
+}
+
+func newUpstreamTransport(base *http.Transport, upstream config.Upstream) (http.RoundTripper, error) {
+	tlsConfig, err := buildTLSConfig(upstream.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("upstream.tls: %w", err)
+	}
+	base.TLSClientConfig = tlsConfig
+	warnInsecure("all upstreams", upstream.TLS)
+
+	if len(upstream.Hosts) == 0 {
+		return base, nil
+	}
+
+	ht := &hostTransport{base: base}
+	for i, h := range upstream.Hosts {
+		merged := upstream.TLS.Merge(h.TLS)
+		cfg, err := buildTLSConfig(merged)
+		if err != nil {
+			return nil, fmt.Errorf("upstream.hosts[%d] (%s): %w", i, h.Match, err)
+		}
+		warnInsecure(h.Match, merged)
+
+		t := base.Clone()
+		t.TLSClientConfig = cfg
+		ht.hosts = append(ht.hosts, hostRoute{match: strings.ToLower(h.Match), transport: t})
+	}
+	return ht, nil
+}
+
+func (h *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	for _, route := range h.hosts {
+		if ok, _ := path.Match(route.match, host); ok || route.match == host {
+			return route.transport.RoundTrip(req)
+		}
+	}
+	return h.base.RoundTrip(req)
+}
+
+func buildTLSConfig(cfg config.UpstreamTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.Insecure(),
+	}
+	if cfg.MinVersion != "" {
+		tlsConfig.MinVersion = config.TLSVersions[cfg.MinVersion]
+	}
+
+	if len(cfg.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range cfg.CAFiles {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("reading CA file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no PEM certificates found in CA file %s", file)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate %s: %w", cfg.ClientCert, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func warnInsecure(scope string, cfg config.UpstreamTLS) {
+	if !cfg.Insecure() {
+		return
+	}
+	logger.LogWarning(fmt.Sprintf("TLS certificate verification is DISABLED for %s (insecure_skip_verify). Traffic can be intercepted; never use this outside local testing.", scope))
+}
+
+func describeTLSError(err error) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+
+	switch {
+	case errors.As(err, &unknownAuthority):
+		issuer := "unknown issuer"
+		if unknownAuthority.Cert != nil {
+			issuer = unknownAuthority.Cert.Issuer.String()
+		}
+		return fmt.Sprintf("TLS handshake failed: certificate signed by unknown authority (%s); add the CA to upstream.tls.ca_files", issuer)
+	case errors.As(err, &hostnameErr):
+		names := hostnameErr.Certificate.DNSNames
+		if len(names) == 0 {
+			names = []string{hostnameErr.Certificate.Subject.CommonName}
+		}
+		return fmt.Sprintf("TLS handshake failed: certificate is valid for %s, not %s; set upstream.tls.server_name if this is expected", strings.Join(names, ", "), hostnameErr.Host)
+	case errors.As(err, &invalidCert):
+		switch invalidCert.Reason {
+		case x509.Expired:
+			return fmt.Sprintf("TLS handshake failed: certificate for %s expired or not yet valid (valid %s to %s)",
+				invalidCert.Cert.Subject.CommonName, invalidCert.Cert.NotBefore.Format("2006-01-02"), invalidCert.Cert.NotAfter.Format("2006-01-02"))
+		}
+		return "TLS handshake failed: invalid certificate: " + invalidCert.Error()
+	case errors.As(err, &recordErr):
+		return "TLS handshake failed: upstream did not answer with TLS (is it plain HTTP?)"
+	case errors.As(err, &alertErr):
+		switch alertErr {
+		case 42, 116:
+			return "TLS handshake failed: upstream requires a client certificate; set upstream.tls.client_cert and client_key"
+		case 70:
+			return "TLS handshake failed: protocol version not supported by upstream; check upstream.tls.min_version"
+		}
+		return "TLS handshake failed: upstream sent alert: " + alertErr.Error()
+	case errors.As(err, &verifyErr):
+		return "TLS handshake failed: " + verifyErr.Err.Error()
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "protocol version not supported"), strings.Contains(msg, "no supported versions"):
+		return "TLS handshake failed: no TLS version in common with upstream; check upstream.tls.min_version"
+	case strings.Contains(msg, "certificate required"), strings.Contains(msg, "bad certificate"):
+		return "TLS handshake failed: upstream rejected the client certificate (or requires one); set upstream.tls.client_cert and client_key"
+	case strings.Contains(msg, "tls:"):
+		return "TLS handshake failed: " + msg[strings.Index(msg, "tls:"):]
+	}
+	return ""
+}
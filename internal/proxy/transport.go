@@ -0,0 +1,313 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"mirage/internal/config"
+	"mirage/internal/secrets"
+	"mirage/internal/socks"
+)
+
+func newTransport(upstream config.Upstream) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxyFunc, err := upstreamProxyFunc(upstream.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = proxyFunc
+
+	dialer := &net.Dialer{Timeout: upstream.DialTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = upstream.TLSTimeout
+	transport.ResponseHeaderTimeout = upstream.HeaderTimeout
+
+	pool := upstream.Pool
+	if pool.MaxIdleConns > 0 {
+		transport.MaxIdleConns = pool.MaxIdleConns
+	}
+	if pool.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+	}
+	if pool.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = pool.MaxConnsPerHost
+	}
+	if pool.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = pool.IdleConnTimeout
+	}
+	return transport, nil
+}
+
+func upstreamProxyFunc(cfg config.UpstreamProxy) (func(*http.Request) (*url.URL, error), error) {
+	switch cfg.URL {
+	case "":
+		if len(cfg.NoProxy) == 0 {
+			return http.ProxyFromEnvironment, nil
+		}
+		bypass := newBypassList(cfg.NoProxy)
+		return func(req *http.Request) (*url.URL, error) {
+			if bypass.matches(req.URL) {
+				return nil, nil
+			}
+			return http.ProxyFromEnvironment(req)
+		}, nil
+	case config.ProxyDirect:
+		return nil, nil
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("upstream proxy: %w", err)
+	}
+	proxyURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("upstream proxy: %w", err)
+	}
+	if cfg.Username != "" {
+		proxyURL.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+	if password, ok := proxyURL.User.Password(); ok {
+		secrets.Register("upstream proxy password", password)
+	}
+
+	noProxy := cfg.NoProxy
+	if len(noProxy) == 0 {
+		noProxy = envNoProxy()
+	}
+	bypass := newBypassList(noProxy)
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypass.matches(req.URL) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+func envNoProxy() []string {
+	value := os.Getenv("NO_PROXY")
+	if value == "" {
+		value = os.Getenv("no_proxy")
+	}
+	var hosts []string
+	for _, h := range strings.Split(value, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+type bypassEntry struct {
+	host string
+	port string
+	net  *net.IPNet
+}
+
+type bypassList struct {
+	all     bool
+	entries []bypassEntry
+}
+
+func newBypassList(hosts []string) *bypassList {
+	b := &bypassList{}
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+		if h == "*" {
+			b.all = true
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(h); err == nil {
+			b.entries = append(b.entries, bypassEntry{net: ipNet})
+			continue
+		}
+		entry := bypassEntry{host: h}
+		if host, port, err := net.SplitHostPort(h); err == nil {
+			entry.host, entry.port = host, port
+		}
+		entry.host = strings.TrimPrefix(entry.host, "*")
+		b.entries = append(b.entries, entry)
+	}
+	return b
+}
+
+func (b *bypassList) matches(u *url.URL) bool {
+	if b.all {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	ip := net.ParseIP(host)
+
+	for _, e := range b.entries {
+		if e.net != nil {
+			if ip != nil && e.net.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if e.port != "" && e.port != port {
+			continue
+		}
+		if strings.HasPrefix(e.host, ".") {
+			if strings.HasSuffix(host, e.host) || host == e.host[1:] {
+				return true
+			}
+			continue
+		}
+		if host == e.host || strings.HasSuffix(host, "."+e.host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) UpstreamProxy() string {
+	cfg := p.upstream.Proxy
+	if cfg.URL == "" && len(cfg.NoProxy) > 0 {
+		return "from environment, bypassing " + strings.Join(cfg.NoProxy, ", ")
+	}
+	if cfg.URL == "" || cfg.URL == config.ProxyDirect {
+		return cfg.URL
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return ""
+	}
+	desc := u.Redacted()
+	if cfg.Username != "" {
+		desc += " (as " + cfg.Username + ")"
+	}
+	if len(cfg.NoProxy) > 0 {
+		desc += ", bypassing " + strings.Join(cfg.NoProxy, ", ")
+	}
+	return desc
+}
+
+func describeProxy(transport *http.Transport, req *http.Request) string {
+	if transport.Proxy == nil {
+		return ""
+	}
+	u, err := transport.Proxy(req)
+	if err != nil || u == nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+func (p *Proxy) DialTunnel(ctx context.Context, network, addr string) (net.Conn, error) {
+	var proxyURL *url.URL
+	if p.transport.Proxy != nil {
+		u, err := p.transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+		if err != nil {
+			return nil, err
+		}
+		proxyURL = u
+	}
+	if proxyURL == nil {
+		return p.transport.DialContext(ctx, network, addr)
+	}
+
+	conn, err := p.transport.DialContext(ctx, "tcp", proxyHostPort(proxyURL))
+	if err != nil {
+		return nil, fmt.Errorf("upstream proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		password, _ := proxyURL.User.Password()
+		err = socks.Connect(conn, addr, proxyURL.User.Username(), password)
+	case "https":
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		conn = tlsConn
+		if err = tlsConn.HandshakeContext(ctx); err == nil {
+			err = connectTunnel(conn, proxyURL, addr)
+		}
+	default:
+		err = connectTunnel(conn, proxyURL, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	return conn, nil
+}
+
+func proxyHostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	switch u.Scheme {
+	case "https":
+		return net.JoinHostPort(u.Hostname(), "443")
+	case "socks5", "socks5h":
+		return net.JoinHostPort(u.Hostname(), "1080")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+func readResponseHead(conn net.Conn) ([]byte, error) {
+	head := make([]byte, 0, 256)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(head, []byte("\r\n\r\n")) {
+		if len(head) > 64<<10 {
+			return nil, fmt.Errorf("CONNECT response headers too large")
+		}
+		if _, err := conn.Read(b); err != nil {
+			return nil, err
+		}
+		head = append(head, b[0])
+	}
+	return head, nil
+}
+
+func connectTunnel(conn net.Conn, proxyURL *url.URL, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	head, err := readResponseHead(conn)
+	if err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	return nil
+}
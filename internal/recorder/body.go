@@ -0,0 +1,90 @@
+package recorder
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"mirage/internal/content"
+	"mirage/internal/redact"
+)
+
+const BodyEncodingBase64 = "base64"
+
+func (d ReqDetail) RawBody() ([]byte, error) {
+	return decodeBody(d.Body, d.BodyEncoding)
+}
+
+func (d RespDetail) RawBody() ([]byte, error) {
+	return decodeBody(d.Body, d.BodyEncoding)
+}
+
+func (d RespDetail) Text() string {
+	if d.DecodedBody != "" || d.BodyEncoding != "" {
+		return d.DecodedBody
+	}
+	return d.Body
+}
+
+func (d ReqDetail) Text() string {
+	if d.DecodedBody != "" || d.BodyEncoding != "" {
+		return d.DecodedBody
+	}
+	return d.Body
+}
+
+func decodeBody(body, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(body), nil
+	case BodyEncodingBase64:
+		return base64.StdEncoding.DecodeString(body)
+	}
+	return nil, fmt.Errorf("unknown body encoding %q", encoding)
+}
+
+func encodeBody(raw []byte, contentType, contentEncoding string, red *redact.Redactor) (body, encoding, decoded string, identity bool) {
+	if len(raw) == 0 {
+		return "", "", "", false
+	}
+
+	if content.Normalize(contentEncoding) == "" {
+		if content.IsText(raw) {
+			return red.Body(contentType, string(raw)), "", "", false
+		}
+		return base64.StdEncoding.EncodeToString(raw), BodyEncodingBase64, "", false
+	}
+
+	plain, err := content.Decode(contentEncoding, raw)
+	if err != nil {
+		if red == nil {
+			return base64.StdEncoding.EncodeToString(raw), BodyEncodingBase64, "", false
+		}
+		return fmt.Sprintf("[body dropped: cannot decode %q content for redaction]", content.Normalize(contentEncoding)), "", "", true
+	}
+	if !content.IsText(plain) {
+		return base64.StdEncoding.EncodeToString(raw), BodyEncodingBase64, "", false
+	}
+
+	text := red.Body(contentType, string(plain))
+	if text != string(plain) {
+		reencoded, err := content.Reencode(contentEncoding, raw, []byte(text))
+		if err != nil {
+			return text, "", "", true
+		}
+		raw = reencoded
+	}
+	return base64.StdEncoding.EncodeToString(raw), BodyEncodingBase64, text, false
+}
+
+func withoutEncoding(h map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for k, v := range h {
+		switch http.CanonicalHeaderKey(k) {
+		case "Content-Encoding", "Content-Length":
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
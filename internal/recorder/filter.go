@@ -0,0 +1,184 @@
+package recorder
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"mirage/internal/config"
+)
+
+type statusRange struct {
+	min, max int
+}
+
+type filterRule struct {
+	hosts        []string
+	paths        []string
+	methods      map[string]bool
+	status       []statusRange
+	contentTypes []string
+}
+
+type Filter struct {
+	include filterRule
+	exclude filterRule
+}
+
+func NewFilter(include, exclude config.RecordFilter) (*Filter, error) {
+	in, err := newFilterRule(include)
+	if err != nil {
+		return nil, fmt.Errorf("recording.include: %w", err)
+	}
+	ex, err := newFilterRule(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("recording.exclude: %w", err)
+	}
+	if in.empty() && ex.empty() {
+		return nil, nil
+	}
+	return &Filter{include: in, exclude: ex}, nil
+}
+
+func newFilterRule(cfg config.RecordFilter) (filterRule, error) {
+	rule := filterRule{
+		hosts:        cfg.Hosts,
+		paths:        cfg.Paths,
+		contentTypes: cfg.ContentTypes,
+	}
+	for _, patterns := range [][]string{cfg.Hosts, cfg.Paths, cfg.ContentTypes} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return rule, fmt.Errorf("invalid pattern %q", pattern)
+			}
+		}
+	}
+	if len(cfg.Methods) > 0 {
+		rule.methods = make(map[string]bool)
+		for _, m := range cfg.Methods {
+			rule.methods[strings.ToUpper(m)] = true
+		}
+	}
+	for _, s := range cfg.Status {
+		r, err := parseStatusRange(s)
+		if err != nil {
+			return rule, err
+		}
+		rule.status = append(rule.status, r)
+	}
+	return rule, nil
+}
+
+func parseStatusRange(s string) (statusRange, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+		base := int(s[0]-'0') * 100
+		return statusRange{base, base + 99}, nil
+	}
+	lo, hi, isRange := strings.Cut(s, "-")
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return statusRange{}, fmt.Errorf("invalid status %q (use 200, 200-299 or 2xx)", s)
+	}
+	if !isRange {
+		return statusRange{min, min}, nil
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil || max < min {
+		return statusRange{}, fmt.Errorf("invalid status range %q", s)
+	}
+	return statusRange{min, max}, nil
+}
+
+func (r filterRule) empty() bool {
+	return len(r.hosts) == 0 && len(r.paths) == 0 && len(r.methods) == 0 &&
+		len(r.status) == 0 && len(r.contentTypes) == 0
+}
+
+func (f *Filter) Allow(req *http.Request, status int, contentType string) (bool, string) {
+	if f == nil {
+		return true, ""
+	}
+
+	host := requestHost(req)
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	in := f.include
+	switch {
+	case len(in.hosts) > 0 && !matchAny(in.hosts, host):
+		return false, "host " + host + " not included"
+	case len(in.paths) > 0 && !matchPath(in.paths, req.URL.Path):
+		return false, "path " + req.URL.Path + " not included"
+	case len(in.methods) > 0 && !in.methods[req.Method]:
+		return false, "method " + req.Method + " not included"
+	case len(in.status) > 0 && !matchStatus(in.status, status):
+		return false, fmt.Sprintf("status %d not included", status)
+	case len(in.contentTypes) > 0 && !matchAny(in.contentTypes, mediaType):
+		return false, "content type " + mediaType + " not included"
+	}
+
+	ex := f.exclude
+	switch {
+	case matchAny(ex.hosts, host):
+		return false, "host " + host + " excluded"
+	case matchPath(ex.paths, req.URL.Path):
+		return false, "path " + req.URL.Path + " excluded"
+	case ex.methods[req.Method]:
+		return false, "method " + req.Method + " excluded"
+	case matchStatus(ex.status, status):
+		return false, fmt.Sprintf("status %d excluded", status)
+	case mediaType != "" && matchAny(ex.contentTypes, mediaType):
+		return false, "content type " + mediaType + " excluded"
+	}
+	return true, ""
+}
+
+func requestHost(req *http.Request) string {
+	host := req.URL.Host
+	if host == "" {
+		host = req.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPath(patterns []string, reqPath string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, reqPath); ok {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/**") && strings.HasPrefix(reqPath, strings.TrimSuffix(pattern, "**")) {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(reqPath)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchStatus(ranges []statusRange, status int) bool {
+	for _, r := range ranges {
+		if status >= r.min && status <= r.max {
+			return true
+		}
+	}
+	return false
+}
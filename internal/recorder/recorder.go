@@ -1,74 +1,165 @@
 package recorder
 
 import (
-	"encoding/json"
 	"net/http"
-	"os"
 	"sync"
 	"time"
+
+	"mirage/internal/config"
+	"mirage/internal/redact"
+	"mirage/internal/timing"
+)
+
+const (
+	SourceProxy    = "proxy"
+	SourceMock     = "mock"
+	SourceCassette = "cassette"
+	SourcePatch    = "patch"
 )
 
 type Interaction struct {
-	Timestamp time.Time  `json:"timestamp"`
-	Request   ReqDetail  `json:"request"`
-	Response  RespDetail `json:"response"`
-	Duration  string     `json:"duration"`
+	Timestamp time.Time      `json:"timestamp"`
+	Source    string         `json:"source,omitempty"`
+	Scenario  string         `json:"scenario,omitempty"`
+	Request   ReqDetail      `json:"request"`
+	Response  RespDetail     `json:"response"`
+	Original  *RespDetail    `json:"original_response,omitempty"`
+	Duration  string         `json:"duration"`
+	Timings   *timing.Phases `json:"timings,omitempty"`
+}
+
+type Meta struct {
+	Source       string
+	Scenario     string
+	Timings      *timing.Phases
+	Original     *http.Response
+	OriginalBody []byte
 }
 
 type ReqDetail struct {
-	Method  string              `json:"method"`
-	URL     string              `json:"url"`
-	Headers map[string][]string `json:"headers"`
-	Body    string              `json:"body"`
+	Method       string              `json:"method"`
+	URL          string              `json:"url"`
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body_encoding,omitempty"`
+	DecodedBody  string              `json:"decoded_body,omitempty"`
 }
 
 type RespDetail struct {
-	Status  int                 `json:"status"`
-	Headers map[string][]string `json:"headers"`
-	Body    string              `json:"body"`
+	Status       int                 `json:"status"`
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body_encoding,omitempty"`
+	DecodedBody  string              `json:"decoded_body,omitempty"`
 }
 
 type Recorder struct {
-	mu           sync.Mutex
-	Interactions []Interaction
-	OutputFile   string
+	mu         sync.Mutex
+	OutputFile string
+	store      store
+	filter     *Filter
+	redactor   *redact.Redactor
+	count      int
 }
 
-func NewRecorder(outputFile string) *Recorder {
+func NewRecorder(cfg config.Recording) (*Recorder, error) {
+	filter, err := NewFilter(cfg.Include, cfg.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	s, err := openStore(cfg)
+	if err != nil {
+		return nil, err
+	}
 	return &Recorder{
-		OutputFile:   outputFile,
-		Interactions: make([]Interaction, 0),
+		OutputFile: cfg.Output,
+		store:      s,
+		filter:     filter,
+	}, nil
+}
+
+func (r *Recorder) Allow(req *http.Request, status int, contentType string) (bool, string) {
+	return r.filter.Allow(req, status, contentType)
+}
+
+func (r *Recorder) SetRedactor(red *redact.Redactor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.redactor = red
+}
+
+func (r *Recorder) Record(req *http.Request, reqBody []byte, resp *http.Response, respBody []byte, duration time.Duration, meta Meta) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	interaction := NewInteraction(req, reqBody, resp, respBody, duration, r.redactor)
+	interaction.Source = meta.Source
+	interaction.Scenario = meta.Scenario
+	interaction.Timings = meta.Timings
+	if meta.Original != nil {
+		original := NewRespDetail(meta.Original, meta.OriginalBody, r.redactor)
+		interaction.Original = &original
 	}
+	if err := r.store.Append(interaction); err != nil {
+		return err
+	}
+	r.count++
+	return nil
 }
 
-func (r *Recorder) Record(req *http.Request, reqBody string, resp *http.Response, respBody string, duration time.Duration) {
+func (r *Recorder) Append(i Interaction) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if err := r.store.Append(i); err != nil {
+		return err
+	}
+	r.count++
+	return nil
+}
+
+func NewInteraction(req *http.Request, reqBody []byte, resp *http.Response, respBody []byte, duration time.Duration, red *redact.Redactor) Interaction {
 	interaction := Interaction{
 		Timestamp: time.Now(),
 		Request: ReqDetail{
 			Method:  req.Method,
-			URL:     req.URL.String(),
-			Headers: req.Header,
-			Body:    reqBody,
-		},
-		Response: RespDetail{
-			Status:  resp.StatusCode,
-			Headers: resp.Header,
-			Body:    respBody,
+			URL:     red.URL(req.URL.String()),
+			Headers: red.Header(req.Header),
 		},
+		Response: NewRespDetail(resp, respBody, red),
 		Duration: duration.String(),
 	}
-
-	r.Interactions = append(r.Interactions, interaction)
-	r.save()
+	var identity bool
+	interaction.Request.Body, interaction.Request.BodyEncoding, interaction.Request.DecodedBody, identity =
+		encodeBody(reqBody, req.Header.Get("Content-Type"), req.Header.Get("Content-Encoding"), red)
+	if identity {
+		interaction.Request.Headers = withoutEncoding(interaction.Request.Headers)
+	}
+	return interaction
 }
 
-func (r *Recorder) save() error {
-	data, err := json.MarshalIndent(r.Interactions, "", "  ")
-	if err != nil {
-		return err
+func NewRespDetail(resp *http.Response, body []byte, red *redact.Redactor) RespDetail {
+	detail := RespDetail{
+		Status:  resp.StatusCode,
+		Headers: red.Header(resp.Header),
+	}
+	var identity bool
+	detail.Body, detail.BodyEncoding, detail.DecodedBody, identity =
+		encodeBody(body, resp.Header.Get("Content-Type"), resp.Header.Get("Content-Encoding"), red)
+	if identity {
+		detail.Headers = withoutEncoding(detail.Headers)
 	}
-	return os.WriteFile(r.OutputFile, data, 0644)
+	return detail
+}
+
+func (r *Recorder) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.store.Close()
 }
@@ -0,0 +1,518 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"mirage/internal/config"
+)
+
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+
+	FsyncAlways   = "always"
+	FsyncInterval = "interval"
+	FsyncNever    = "never"
+
+	defaultFsyncInterval = time.Second
+)
+
+type store interface {
+	Append(Interaction) error
+	Close() error
+}
+
+func DetectFormat(path string) string {
+	name := strings.TrimSuffix(strings.ToLower(path), ".gz")
+	switch filepath.Ext(name) {
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	}
+	return FormatJSON
+}
+
+func openStore(cfg config.Recording) (store, error) {
+	format := cfg.Format
+	if format == "" {
+		format = DetectFormat(cfg.Output)
+	}
+
+	switch format {
+	case FormatJSON:
+		return openJSONStore(cfg.Output)
+	case FormatNDJSON:
+		return openNDJSONStore(cfg)
+	}
+	return nil, fmt.Errorf("unknown recording format %q (use json or ndjson)", format)
+}
+
+type jsonStore struct {
+	file  *os.File
+	end   int64
+	count int
+}
+
+func openJSONStore(path string) (*jsonStore, error) {
+	existing, err := loadJSONArray(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeJSONArray(path, existing); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	s := &jsonStore{file: f, count: len(existing), end: info.Size() - 1}
+	if s.count > 0 {
+		s.end--
+	}
+	return s, nil
+}
+
+func loadJSONArray(path string) ([]Interaction, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if data[0] != '[' {
+		return nil, fmt.Errorf("%s exists and is not a JSON array; choose another output or use format: ndjson", path)
+	}
+	var interactions []Interaction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("%s exists but cannot be appended to: %w", path, err)
+	}
+	return interactions, nil
+}
+
+func (s *jsonStore) Append(i Interaction) error {
+	data, err := json.MarshalIndent(i, "  ", "  ")
+	if err != nil {
+		return err
+	}
+
+	sep := ",\n  "
+	if s.count == 0 {
+		sep = "\n  "
+	}
+	chunk := make([]byte, 0, len(sep)+len(data)+2)
+	chunk = append(chunk, sep...)
+	chunk = append(chunk, data...)
+	chunk = append(chunk, "\n]"...)
+
+	if _, err := s.file.WriteAt(chunk, s.end); err != nil {
+		return err
+	}
+	s.end += int64(len(chunk)) - 2
+	s.count++
+	return nil
+}
+
+func (s *jsonStore) Close() error {
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+type ndjsonStore struct {
+	mu       sync.Mutex
+	cfg      config.Recording
+	file     *os.File
+	size     int64
+	opened   time.Time
+	dirty    bool
+	stopSync chan struct{}
+	syncDone chan struct{}
+}
+
+func openNDJSONStore(cfg config.Recording) (*ndjsonStore, error) {
+	switch cfg.Fsync {
+	case "":
+		cfg.Fsync = FsyncInterval
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		return nil, fmt.Errorf("unknown fsync policy %q (use always, interval or never)", cfg.Fsync)
+	}
+	if cfg.FsyncInterval <= 0 {
+		cfg.FsyncInterval = defaultFsyncInterval
+	}
+
+	s := &ndjsonStore{cfg: cfg}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Fsync == FsyncInterval {
+		s.stopSync = make(chan struct{})
+		s.syncDone = make(chan struct{})
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+func (s *ndjsonStore) open() error {
+	f, err := os.OpenFile(s.cfg.Output, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	size, err := trimTornLine(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = size
+	s.opened = time.Now()
+	return nil
+}
+
+func trimTornLine(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return 0, nil
+	}
+
+	const chunk = 64 * 1024
+	buf := make([]byte, chunk)
+	for end := size; end > 0; {
+		start := max(end-chunk, 0)
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			keep := start + int64(i) + 1
+			if keep == size {
+				return size, nil
+			}
+			return keep, f.Truncate(keep)
+		}
+		end = start
+	}
+	return 0, f.Truncate(0)
+}
+
+func (s *ndjsonStore) Append(i Interaction) error {
+	line, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shouldRotate(int64(len(line))) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if s.cfg.Fsync == FsyncAlways {
+		return s.file.Sync()
+	}
+	s.dirty = true
+	return nil
+}
+
+func (s *ndjsonStore) shouldRotate(next int64) bool {
+	if s.size == 0 {
+		return false
+	}
+	if s.cfg.MaxSizeMB > 0 && s.size+next > int64(s.cfg.MaxSizeMB)<<20 {
+		return true
+	}
+	return s.cfg.MaxAge > 0 && time.Since(s.opened) > s.cfg.MaxAge
+}
+
+func (s *ndjsonStore) rotate() error {
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(s.cfg.Output)
+	base := strings.TrimSuffix(s.cfg.Output, ext)
+	rotated := fmt.Sprintf("%s-%s%s", base, time.Now().Format("20060102T150405.000"), ext)
+	if err := os.Rename(s.cfg.Output, rotated); err != nil {
+		return err
+	}
+
+	if s.cfg.Compress {
+		if err := gzipFile(rotated); err != nil {
+			return err
+		}
+	}
+	return s.open()
+}
+
+func (s *ndjsonStore) syncLoop() {
+	defer close(s.syncDone)
+	ticker := time.NewTicker(s.cfg.FsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty {
+				s.file.Sync()
+				s.dirty = false
+			}
+			s.mu.Unlock()
+		case <-s.stopSync:
+			return
+		}
+	}
+}
+
+func (s *ndjsonStore) Close() error {
+	if s.stopSync != nil {
+		close(s.stopSync)
+		<-s.syncDone
+		s.stopSync = nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	return s.file.Close()
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func Load(path string) ([]Interaction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+
+	first, err := firstNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if first == '[' {
+		var interactions []Interaction
+		if err := json.NewDecoder(br).Decode(&interactions); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return interactions, nil
+	}
+	return readNDJSON(path, br)
+}
+
+func firstNonSpace(br *bufio.Reader) (byte, error) {
+	for i := 1; ; i++ {
+		peek, err := br.Peek(i)
+		if len(peek) < i {
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		c := peek[i-1]
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c, nil
+		}
+	}
+}
+
+func readNDJSON(path string, r io.Reader) ([]Interaction, error) {
+	var interactions []Interaction
+	var torn error
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if torn != nil {
+			return interactions, torn
+		}
+		var i Interaction
+		if err := json.Unmarshal(data, &i); err != nil {
+			torn = fmt.Errorf("%s:%d: %w", path, line, err)
+			continue
+		}
+		interactions = append(interactions, i)
+	}
+	return interactions, scanner.Err()
+}
+
+func LoadAll(paths []string) ([]Interaction, error) {
+	var all []Interaction
+	for _, path := range paths {
+		interactions, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, interactions...)
+	}
+	return all, nil
+}
+
+func Save(path, format string, interactions []Interaction) error {
+	if format == "" {
+		format = DetectFormat(path)
+	}
+
+	data, err := encode(format, interactions)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	return writeFileAtomic(path, data)
+}
+
+func encode(format string, interactions []Interaction) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		if interactions == nil {
+			interactions = []Interaction{}
+		}
+		return json.MarshalIndent(interactions, "", "  ")
+	case FormatNDJSON:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, i := range interactions {
+			if err := enc.Encode(i); err != nil {
+				return nil, err
+			}
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown recording format %q (use json or ndjson)", format)
+}
+
+func writeJSONArray(path string, interactions []Interaction) error {
+	data, err := encode(FormatJSON, interactions)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
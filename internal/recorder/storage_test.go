@@ -0,0 +1,136 @@
+package recorder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mirage/internal/config"
+)
+
+func interaction(duration string) Interaction {
+	return Interaction{
+		Request:  ReqDetail{Method: "GET", URL: "http://example.com/" + duration},
+		Response: RespDetail{Status: 200},
+		Duration: duration,
+	}
+}
+
+func appendAll(t *testing.T, cfg config.Recording, durations ...string) {
+	t.Helper()
+	s, err := openStore(cfg)
+	if err != nil {
+		t.Fatalf("openStore: %v", err)
+	}
+	for _, d := range durations {
+		if err := s.Append(interaction(d)); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func durations(interactions []Interaction) string {
+	out := make([]string, len(interactions))
+	for i, in := range interactions {
+		out[i] = in.Duration
+	}
+	return strings.Join(out, ",")
+}
+
+func TestStoreAppendAndReopen(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		existing string
+		sessions [][]string
+		want     string
+	}{
+		{name: "json new file", file: "a.json", sessions: [][]string{{"1s", "2s"}}, want: "1s,2s"},
+		{name: "json reopen", file: "a.json", sessions: [][]string{{"1s"}, {"2s", "3s"}}, want: "1s,2s,3s"},
+		{name: "json empty session", file: "a.json", sessions: [][]string{{}, {"1s"}}, want: "1s"},
+		{name: "json existing empty file", file: "a.json", existing: "\n", sessions: [][]string{{"1s"}}, want: "1s"},
+		{name: "ndjson new file", file: "a.ndjson", sessions: [][]string{{"1s", "2s"}}, want: "1s,2s"},
+		{name: "ndjson reopen", file: "a.ndjson", sessions: [][]string{{"1s"}, {"2s"}}, want: "1s,2s"},
+		{name: "ndjson reopen after torn line", file: "a.ndjson", existing: "{\"duration\":\"0s\"}\n{\"dura", sessions: [][]string{{"1s"}}, want: "0s,1s"},
+		{name: "ndjson reopen after only a torn line", file: "a.ndjson", existing: "{\"dura", sessions: [][]string{{"1s"}}, want: "1s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if tt.existing != "" {
+				if err := os.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, session := range tt.sessions {
+				appendAll(t, config.Recording{Output: path, Fsync: FsyncNever}, session...)
+			}
+
+			got, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if d := durations(got); d != tt.want {
+				t.Errorf("got %q, want %q", d, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONStoreRefusesNonArray(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+	}{
+		{name: "object", existing: `{"a": 1}`},
+		{name: "ndjson", existing: "{\"duration\":\"1s\"}\n{\"duration\":\"2s\"}\n"},
+		{name: "truncated array", existing: `[{"duration": "1s"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.json")
+			if err := os.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := openStore(config.Recording{Output: path}); err == nil {
+				t.Fatal("expected an error")
+			}
+			data, _ := os.ReadFile(path)
+			if string(data) != tt.existing {
+				t.Errorf("file was modified: %q", data)
+			}
+		})
+	}
+}
+
+func TestReadNDJSONTornLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "complete", data: "{\"duration\":\"1s\"}\n{\"duration\":\"2s\"}\n", want: "1s,2s"},
+		{name: "torn last line", data: "{\"duration\":\"1s\"}\n{\"durat", want: "1s"},
+		{name: "torn last line with blank lines", data: "{\"duration\":\"1s\"}\n{\"durat\n\n", want: "1s"},
+		{name: "corrupt middle line", data: "{\"duration\":\"1s\"}\n{\"durat\n{\"duration\":\"2s\"}\n", want: "1s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readNDJSON("test.ndjson", strings.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if d := durations(got); d != tt.want {
+				t.Errorf("got %q, want %q", d, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package redact
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"mirage/internal/config"
+	"mirage/internal/jsonpath"
+)
+
+const (
+	StrategyPlaceholder = "placeholder"
+	StrategyRemove      = "remove"
+	StrategyHash        = "hash"
+
+	DefaultPlaceholder = "[REDACTED]"
+)
+
+type Redactor struct {
+	strategy    string
+	placeholder string
+	headers     map[string]bool
+	queryParams map[string]bool
+	formFields  map[string]bool
+	jsonPaths   []jsonpath.Path
+	patterns    []*regexp.Regexp
+}
+
+func New(cfg config.Redaction) (*Redactor, error) {
+	r := &Redactor{
+		strategy:    cfg.Strategy,
+		placeholder: cfg.Placeholder,
+		headers:     make(map[string]bool),
+		queryParams: make(map[string]bool),
+		formFields:  make(map[string]bool),
+	}
+
+	switch r.strategy {
+	case "":
+		r.strategy = StrategyPlaceholder
+	case StrategyPlaceholder, StrategyRemove, StrategyHash:
+	default:
+		return nil, fmt.Errorf("redact.strategy must be one of placeholder, remove, hash; got %q", cfg.Strategy)
+	}
+	if r.placeholder == "" {
+		r.placeholder = DefaultPlaceholder
+	}
+
+	for _, h := range cfg.Headers {
+		r.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, q := range cfg.QueryParams {
+		r.queryParams[q] = true
+	}
+	for _, f := range cfg.FormFields {
+		r.formFields[f] = true
+	}
+	for _, expr := range cfg.JSONPaths {
+		p, err := jsonpath.Parse(expr)
+		if err != nil {
+			return nil, fmt.Errorf("redact.json_paths: %w", err)
+		}
+		r.jsonPaths = append(r.jsonPaths, p)
+	}
+	for _, expr := range cfg.Patterns {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("redact.patterns: %w", err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	if r.empty() {
+		return nil, nil
+	}
+	return r, nil
+}
+
+func (r *Redactor) empty() bool {
+	return len(r.headers) == 0 && len(r.queryParams) == 0 && len(r.formFields) == 0 &&
+		len(r.jsonPaths) == 0 && len(r.patterns) == 0
+}
+
+func (r *Redactor) Header(h http.Header) http.Header {
+	if r == nil || h == nil {
+		return h
+	}
+
+	out := make(http.Header, len(h))
+	for k, vv := range h {
+		if !r.headers[http.CanonicalHeaderKey(k)] {
+			values := make([]string, len(vv))
+			for i, v := range vv {
+				values[i] = r.applyPatterns(v)
+			}
+			out[k] = values
+			continue
+		}
+		if r.strategy == StrategyRemove {
+			continue
+		}
+		values := make([]string, len(vv))
+		for i, v := range vv {
+			values[i] = r.mask(v)
+		}
+		out[k] = values
+	}
+	return out
+}
+
+func (r *Redactor) URL(raw string) string {
+	if r == nil || raw == "" {
+		return raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.RawQuery == "" || len(r.queryParams) == 0 {
+		return r.applyPatterns(raw)
+	}
+
+	u.RawQuery = r.redactValues(u.RawQuery, r.queryParams)
+	return r.applyPatterns(u.String())
+}
+
+func (r *Redactor) Body(contentType, body string) string {
+	if r == nil || body == "" {
+		return body
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case len(r.jsonPaths) > 0 && isJSON(mediaType, body):
+		body = r.redactJSON(body)
+	case len(r.formFields) > 0 && mediaType == "application/x-www-form-urlencoded":
+		body = r.redactValues(body, r.formFields)
+	}
+	return r.applyPatterns(body)
+}
+
+func (r *Redactor) redactValues(encoded string, names map[string]bool) string {
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return encoded
+	}
+
+	changed := false
+	for name, vv := range values {
+		if !names[name] {
+			continue
+		}
+		changed = true
+		if r.strategy == StrategyRemove {
+			values.Del(name)
+			continue
+		}
+		for i, v := range vv {
+			vv[i] = r.mask(v)
+		}
+	}
+	if !changed {
+		return encoded
+	}
+	return values.Encode()
+}
+
+func (r *Redactor) redactJSON(body string) string {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return body
+	}
+
+	changed := false
+	for _, p := range r.jsonPaths {
+		var ok bool
+		if r.strategy == StrategyRemove {
+			doc, ok = p.Delete(doc)
+		} else {
+			doc, ok = p.Replace(doc, func(v any) any {
+				return r.mask(jsonString(v))
+			})
+		}
+		changed = changed || ok
+	}
+	if !changed {
+		return body
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if strings.Contains(body, "\n") {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(doc); err != nil {
+		return body
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func (r *Redactor) applyPatterns(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllStringFunc(s, r.mask)
+	}
+	return s
+}
+
+func (r *Redactor) mask(value string) string {
+	switch r.strategy {
+	case StrategyRemove:
+		return ""
+	case StrategyHash:
+		sum := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(sum[:6])
+	default:
+		return r.placeholder
+	}
+}
+
+func jsonString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func isJSON(mediaType, body string) bool {
+	if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+		return true
+	}
+	trimmed := strings.TrimSpace(body)
+	return mediaType == "" && (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "["))
+}
@@ -0,0 +1,408 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"mirage/internal/jsonpath"
+	"mirage/internal/logger"
+	"mirage/internal/recorder"
+)
+
+const (
+	CaptureJSON   = "json"
+	CaptureHeader = "header"
+	CaptureRegex  = "regex"
+
+	minTextSubstitution = 4
+)
+
+type capture struct {
+	name   string
+	source string
+	header string
+	path   jsonpath.Path
+	re     *regexp.Regexp
+}
+
+type chain struct {
+	captures []capture
+
+	mu      sync.Mutex
+	order   []string
+	vars    map[string]substitution
+	skipped map[string]bool
+}
+
+func newChain(specs []string) (*chain, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	c := &chain{vars: make(map[string]substitution), skipped: make(map[string]bool)}
+	for _, spec := range specs {
+		cp, err := parseCapture(spec)
+		if err != nil {
+			return nil, err
+		}
+		c.captures = append(c.captures, cp)
+	}
+	return c, nil
+}
+
+func parseCapture(spec string) (capture, error) {
+	name, rule, ok := strings.Cut(spec, "=")
+	source, expr, ok2 := strings.Cut(rule, ":")
+	if !ok || !ok2 || name == "" || expr == "" {
+		return capture{}, fmt.Errorf("invalid capture %q (want name=json:$.path, name=header:Name or name=regex:pattern)", spec)
+	}
+
+	cp := capture{name: name, source: source}
+	switch source {
+	case CaptureJSON:
+		path, err := jsonpath.Parse(expr)
+		if err != nil {
+			return capture{}, fmt.Errorf("invalid capture %q: %w", spec, err)
+		}
+		cp.path = path
+	case CaptureHeader:
+		cp.header = expr
+	case CaptureRegex:
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return capture{}, fmt.Errorf("invalid capture %q: %w", spec, err)
+		}
+		cp.re = re
+	default:
+		return capture{}, fmt.Errorf("invalid capture %q: unknown source %q (use json, header or regex)", spec, source)
+	}
+	return cp, nil
+}
+
+func (cp capture) extract(resp recorder.RespDetail) (string, bool) {
+	switch cp.source {
+	case CaptureHeader:
+		v := http.Header(resp.Headers).Get(cp.header)
+		return v, v != ""
+	case CaptureRegex:
+		m := cp.re.FindStringSubmatch(resp.Text())
+		if m == nil {
+			return "", false
+		}
+		if len(m) > 1 {
+			return m[1], m[1] != ""
+		}
+		return m[0], m[0] != ""
+	}
+
+	dec := json.NewDecoder(strings.NewReader(resp.Text()))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return "", false
+	}
+	values := cp.path.Get(doc)
+	if len(values) == 0 || values[0] == nil {
+		return "", false
+	}
+	switch v := values[0].(type) {
+	case string:
+		return v, v != ""
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return fmt.Sprint(v), true
+	}
+	data, err := json.Marshal(values[0])
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+func (c *chain) observe(recorded, live recorder.RespDetail) map[string]string {
+	captured := make(map[string]string)
+	for _, cp := range c.captures {
+		old, ok := cp.extract(recorded)
+		if !ok {
+			continue
+		}
+		current, ok := cp.extract(live)
+		if !ok {
+			continue
+		}
+		captured[cp.name] = current
+
+		c.mu.Lock()
+		if _, exists := c.vars[cp.name]; !exists {
+			c.order = append(c.order, cp.name)
+		}
+		c.vars[cp.name] = substitution{name: cp.name, old: old, new: current}
+		c.mu.Unlock()
+	}
+	if len(captured) == 0 {
+		return nil
+	}
+	return captured
+}
+
+func (c *chain) substitutions() []substitution {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subs := make([]substitution, 0, len(c.order))
+	for _, name := range c.order {
+		if s := c.vars[name]; s.old != s.new {
+			subs = append(subs, s)
+		}
+	}
+	return subs
+}
+
+func (c *chain) apply(detail recorder.ReqDetail, body []byte) (recorder.ReqDetail, []byte) {
+	subs := c.substitutions()
+	if len(subs) == 0 {
+		return detail, body
+	}
+
+	detail.URL = substituteURL(detail.URL, subs)
+	headers := make(map[string][]string, len(detail.Headers))
+	for k, vv := range detail.Headers {
+		values := make([]string, len(vv))
+		for i, v := range vv {
+			for _, sub := range subs {
+				v = replaceBounded(v, sub, isHeaderDelim)
+			}
+			values[i] = v
+		}
+		headers[k] = values
+	}
+	detail.Headers = headers
+
+	h := http.Header(detail.Headers)
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return detail, transformBody(h.Get("Content-Encoding"), body, func(plain []byte) []byte {
+		switch {
+		case mediaType == "application/x-www-form-urlencoded":
+			return []byte(substituteQuery(string(plain), subs))
+		case json.Valid(plain):
+			return substituteJSON(plain, subs)
+		}
+		return c.substituteText(plain, subs)
+	})
+}
+
+func substituteURL(raw string, subs []substitution) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+
+	segments := strings.Split(u.EscapedPath(), "/")
+	changed := false
+	for i, seg := range segments {
+		value, err := url.PathUnescape(seg)
+		if err != nil {
+			continue
+		}
+		for _, sub := range subs {
+			if value == sub.old {
+				segments[i] = url.PathEscape(sub.new)
+				changed = true
+				break
+			}
+		}
+	}
+	if changed {
+		if parsed, err := url.Parse(strings.Join(segments, "/")); err == nil {
+			u.Path, u.RawPath = parsed.Path, parsed.RawPath
+		}
+	}
+
+	if u.RawQuery != "" {
+		u.RawQuery = substituteQuery(u.RawQuery, subs)
+	}
+	return u.String()
+}
+
+func substituteQuery(encoded string, subs []substitution) string {
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return encoded
+	}
+	changed := false
+	for _, vv := range values {
+		for i, v := range vv {
+			for _, sub := range subs {
+				if v == sub.old {
+					vv[i] = sub.new
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	if !changed {
+		return encoded
+	}
+	return values.Encode()
+}
+
+type jsonFrame struct {
+	object bool
+	key    bool
+}
+
+func substituteJSON(doc []byte, subs []substitution) []byte {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+
+	var out bytes.Buffer
+	var stack []*jsonFrame
+	copied := int64(0)
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return doc
+		}
+
+		var top *jsonFrame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if d, ok := tok.(json.Delim); ok {
+			switch d {
+			case '{', '[':
+				if top != nil && top.object {
+					top.key = true
+				}
+				stack = append(stack, &jsonFrame{object: d == '{', key: true})
+			default:
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		if top != nil && top.object {
+			top.key = !top.key
+			if !top.key {
+				continue
+			}
+		}
+
+		var value string
+		switch v := tok.(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		default:
+			continue
+		}
+		for _, sub := range subs {
+			if value != sub.old {
+				continue
+			}
+			end := dec.InputOffset()
+			raw := doc[start:end]
+			lit := bytes.TrimLeft(raw, " \t\r\n,:")
+			litStart := end - int64(len(lit))
+
+			replacement, _ := json.Marshal(sub.new)
+			if _, isNumber := tok.(json.Number); isNumber && isJSONNumber(sub.new) {
+				replacement = []byte(sub.new)
+			}
+			out.Write(doc[copied:litStart])
+			out.Write(replacement)
+			copied = end
+			break
+		}
+	}
+	if copied == 0 {
+		return doc
+	}
+	out.Write(doc[copied:])
+	return out.Bytes()
+}
+
+func isJSONNumber(s string) bool {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	if dec.Decode(&v) != nil || dec.More() {
+		return false
+	}
+	_, ok := v.(json.Number)
+	return ok && dec.InputOffset() == int64(len(s))
+}
+
+func (c *chain) substituteText(text []byte, subs []substitution) []byte {
+	s := string(text)
+	for _, sub := range subs {
+		if len(sub.old) < minTextSubstitution {
+			if strings.Contains(s, sub.old) {
+				c.warnSkipped(sub)
+			}
+			continue
+		}
+		s = replaceBounded(s, sub, isWordDelim)
+	}
+	return []byte(s)
+}
+
+func (c *chain) warnSkipped(sub substitution) {
+	c.mu.Lock()
+	seen := c.skipped[sub.name]
+	c.skipped[sub.name] = true
+	c.mu.Unlock()
+	if !seen {
+		logger.LogWarning(fmt.Sprintf("Capture %s: recorded value %q is too short to substitute safely in plain-text bodies; skipping", sub.name, sub.old))
+	}
+}
+
+func replaceBounded(s string, sub substitution, delim func(byte) bool) string {
+	if sub.old == "" || !strings.Contains(s, sub.old) {
+		return s
+	}
+
+	var b strings.Builder
+	rest := s
+	offset := 0
+	for {
+		i := strings.Index(rest, sub.old)
+		if i < 0 {
+			break
+		}
+		at := offset + i
+		end := at + len(sub.old)
+		if (at == 0 || delim(s[at-1])) && (end == len(s) || delim(s[end])) {
+			b.WriteString(s[offset:at])
+			b.WriteString(sub.new)
+		} else {
+			b.WriteString(s[offset:end])
+		}
+		offset = end
+		rest = s[offset:]
+	}
+	b.WriteString(rest)
+	return b.String()
+}
+
+func isHeaderDelim(c byte) bool {
+	return c == ' ' || c == ',' || c == ';' || c == '=' || c == '"'
+}
+
+func isWordDelim(c byte) bool {
+	return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.')
+}
@@ -0,0 +1,109 @@
+package replay
+
+import "testing"
+
+func TestSubstituteJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		sub  substitution
+		want string
+	}{
+		{name: "string value", doc: `{"token":"abc123"}`, sub: substitution{old: "abc123", new: "xyz789"}, want: `{"token":"xyz789"}`},
+		{name: "key left alone", doc: `{"abc123":"abc123"}`, sub: substitution{old: "abc123", new: "xyz789"}, want: `{"abc123":"xyz789"}`},
+		{name: "nested object key", doc: `{"a":{"abc123":1},"b":"abc123"}`, sub: substitution{old: "abc123", new: "x"}, want: `{"a":{"abc123":1},"b":"x"}`},
+		{name: "array values", doc: `["abc123", "other", "abc123"]`, sub: substitution{old: "abc123", new: "x"}, want: `["x", "other", "x"]`},
+		{name: "key after array value", doc: `{"a":["abc123"],"abc123":"abc123"}`, sub: substitution{old: "abc123", new: "x"}, want: `{"a":["x"],"abc123":"x"}`},
+		{name: "partial string untouched", doc: `{"id":"abc1234"}`, sub: substitution{old: "abc123", new: "x"}, want: `{"id":"abc1234"}`},
+		{name: "number stays number", doc: `{"id": 42}`, sub: substitution{old: "42", new: "43"}, want: `{"id": 43}`},
+		{name: "number becomes string", doc: `{"id":42}`, sub: substitution{old: "42", new: "a-42"}, want: `{"id":"a-42"}`},
+		{name: "short value", doc: `{"n":"7","k":7}`, sub: substitution{old: "7", new: "8"}, want: `{"n":"8","k":8}`},
+		{name: "escaped replacement", doc: `{"q":"old1"}`, sub: substitution{old: "old1", new: `a"b`}, want: `{"q":"a\"b"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(substituteJSON([]byte(tt.doc), []substitution{tt.sub}))
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubstituteURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		sub  substitution
+		want string
+	}{
+		{name: "path segment", url: "http://api.test/orders/abc123/items", sub: substitution{old: "abc123", new: "xyz"}, want: "http://api.test/orders/xyz/items"},
+		{name: "partial segment untouched", url: "http://api.test/orders/abc1234", sub: substitution{old: "abc123", new: "xyz"}, want: "http://api.test/orders/abc1234"},
+		{name: "query value", url: "http://api.test/search?id=abc123&page=2", sub: substitution{old: "abc123", new: "xyz"}, want: "http://api.test/search?id=xyz&page=2"},
+		{name: "query key untouched", url: "http://api.test/search?abc123=1", sub: substitution{old: "abc123", new: "xyz"}, want: "http://api.test/search?abc123=1"},
+		{name: "short segment", url: "http://api.test/users/7", sub: substitution{old: "7", new: "8"}, want: "http://api.test/users/8"},
+		{name: "short value inside segment", url: "http://api.test/v7/users", sub: substitution{old: "7", new: "8"}, want: "http://api.test/v7/users"},
+		{name: "escaped replacement", url: "http://api.test/files/abc123", sub: substitution{old: "abc123", new: "a b"}, want: "http://api.test/files/a%20b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := substituteURL(tt.url, []substitution{tt.sub}); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceBoundedHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		sub   substitution
+		want  string
+	}{
+		{name: "bearer token", value: "Bearer abc123", sub: substitution{old: "abc123", new: "xyz"}, want: "Bearer xyz"},
+		{name: "cookie value", value: "session=abc123; theme=dark", sub: substitution{old: "abc123", new: "xyz"}, want: "session=xyz; theme=dark"},
+		{name: "cookie name untouched", value: "abc123x=1", sub: substitution{old: "abc123", new: "xyz"}, want: "abc123x=1"},
+		{name: "inside token untouched", value: "Bearer xabc123", sub: substitution{old: "abc123", new: "xyz"}, want: "Bearer xabc123"},
+		{name: "repeated", value: "abc123, abc123", sub: substitution{old: "abc123", new: "x"}, want: "x, x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replaceBounded(tt.value, tt.sub, isHeaderDelim); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubstituteText(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		sub         substitution
+		want        string
+		wantSkipped bool
+	}{
+		{name: "whole word", text: "order abc123 shipped", sub: substitution{name: "order", old: "abc123", new: "xyz"}, want: "order xyz shipped"},
+		{name: "inside word untouched", text: "order abc1234 shipped", sub: substitution{name: "order", old: "abc123", new: "xyz"}, want: "order abc1234 shipped"},
+		{name: "short value skipped", text: "id 42 of 420", sub: substitution{name: "id", old: "42", new: "43"}, want: "id 42 of 420", wantSkipped: true},
+		{name: "short value absent", text: "nothing here", sub: substitution{name: "id", old: "42", new: "43"}, want: "nothing here"},
+		{name: "minimum length", text: "id=abcd", sub: substitution{name: "id", old: "abcd", new: "wxyz"}, want: "id=wxyz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &chain{vars: make(map[string]substitution), skipped: make(map[string]bool)}
+			got := string(c.substituteText([]byte(tt.text), []substitution{tt.sub}))
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if c.skipped[tt.sub.name] != tt.wantSkipped {
+				t.Errorf("skipped = %v, want %v", c.skipped[tt.sub.name], tt.wantSkipped)
+			}
+		})
+	}
+}
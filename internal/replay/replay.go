@@ -0,0 +1,335 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"mirage/internal/diff"
+	"mirage/internal/errclass"
+	"mirage/internal/recorder"
+)
+
+type Options struct {
+	Assert        bool
+	AssertHeaders []string
+	IgnorePaths   []string
+	Tolerance     float64
+	Timeout       time.Duration
+
+	Concurrency int
+	RPS         float64
+	Speed       float64
+	Duration    time.Duration
+	WarmUp      time.Duration
+
+	Target      string
+	HostMap     []string
+	Headers     []string
+	DropHeaders []string
+	PathReplace []string
+	BodyReplace []string
+
+	Captures []string
+}
+
+type Result struct {
+	Index       int                  `json:"index"`
+	Iteration   int                  `json:"iteration,omitempty"`
+	Warmup      bool                 `json:"warmup,omitempty"`
+	Method      string               `json:"method"`
+	URL         string               `json:"url"`
+	Status      int                  `json:"status"`
+	Expected    int                  `json:"expected_status"`
+	Duration    time.Duration        `json:"duration"`
+	Error       string               `json:"error,omitempty"`
+	Failures    []diff.Change        `json:"failures,omitempty"`
+	Captured    map[string]string    `json:"captured,omitempty"`
+	Interaction recorder.Interaction `json:"-"`
+	Response    *recorder.RespDetail `json:"-"`
+
+	errKind string
+}
+
+func (r Result) Passed() bool {
+	return r.Error == "" && len(r.Failures) == 0
+}
+
+const (
+	maxFailures      = 1000
+	maxPassedResults = 10_000
+)
+
+type Runner struct {
+	opts        Options
+	client      *http.Client
+	diffOpts    diff.Options
+	rewriter    *rewriter
+	chain       *chain
+	OnResult    func(Result)
+	KeepResults bool
+
+	mu       sync.Mutex
+	measured time.Duration
+	stats    collector
+	failures int
+	passed   int
+}
+
+type job struct {
+	index       int
+	iteration   int
+	warmup      bool
+	interaction recorder.Interaction
+}
+
+func NewRunner(opts Options) (*Runner, error) {
+	diffOpts, err := diff.NewOptions(opts.IgnorePaths, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	diffOpts.Tolerance = opts.Tolerance
+
+	if len(opts.Captures) > 0 && opts.Concurrency > 1 {
+		return nil, fmt.Errorf("--capture relies on request order and cannot be combined with --concurrency %d", opts.Concurrency)
+	}
+
+	rw, err := newRewriter(opts)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := newChain(opts.Captures)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = opts.Concurrency
+
+	return &Runner{
+		opts:     opts,
+		diffOpts: diffOpts,
+		rewriter: rw,
+		chain:    ch,
+		stats:    newCollector(),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+func (r *Runner) Run(interactions []recorder.Interaction) []Result {
+	jobs := make(chan job)
+	var results []Result
+	var wg sync.WaitGroup
+
+	for w := 0; w < r.opts.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				result := r.replay(j.index, j.interaction)
+				result.Iteration = j.iteration
+				result.Warmup = j.warmup
+
+				r.mu.Lock()
+				r.stats.add(result)
+				if r.OnResult != nil {
+					r.OnResult(result)
+				}
+				if r.keep(result) {
+					result.Interaction = recorder.Interaction{}
+					result.Response = nil
+					results = append(results, result)
+				}
+				r.mu.Unlock()
+			}
+		}()
+	}
+
+	start := time.Now()
+	r.schedule(interactions, jobs, start)
+	close(jobs)
+	wg.Wait()
+
+	measureStart := start
+	if r.opts.WarmUp > 0 {
+		measureStart = start.Add(r.opts.WarmUp)
+	}
+	r.measured = time.Since(measureStart)
+
+	sort.SliceStable(results, func(a, b int) bool {
+		if results[a].Iteration != results[b].Iteration {
+			return results[a].Iteration < results[b].Iteration
+		}
+		return results[a].Index < results[b].Index
+	})
+	return results
+}
+
+func (r *Runner) keep(result Result) bool {
+	if result.Warmup {
+		return false
+	}
+	if result.Passed() {
+		if !r.KeepResults {
+			return false
+		}
+		r.passed++
+		return r.passed <= maxPassedResults
+	}
+	r.failures++
+	return r.failures <= maxFailures
+}
+
+func (r *Runner) schedule(interactions []recorder.Interaction, jobs chan<- job, start time.Time) {
+	if len(interactions) == 0 {
+		return
+	}
+
+	var tick <-chan time.Time
+	if r.opts.RPS > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.opts.RPS))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var deadline time.Time
+	if r.opts.Duration > 0 {
+		deadline = start.Add(r.opts.WarmUp + r.opts.Duration)
+	}
+
+	first := interactions[0].Timestamp
+	for _, in := range interactions {
+		if in.Timestamp.Before(first) {
+			first = in.Timestamp
+		}
+	}
+
+	for iteration := 0; ; iteration++ {
+		iterationStart := time.Now()
+		for i, in := range interactions {
+			if r.opts.Speed > 0 {
+				offset := time.Duration(float64(in.Timestamp.Sub(first)) / r.opts.Speed)
+				time.Sleep(time.Until(iterationStart.Add(offset)))
+			}
+			if tick != nil {
+				<-tick
+			}
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return
+			}
+			jobs <- job{
+				index:       i,
+				iteration:   iteration,
+				warmup:      time.Since(start) < r.opts.WarmUp,
+				interaction: in,
+			}
+		}
+		if deadline.IsZero() {
+			return
+		}
+	}
+}
+
+func (r *Runner) replay(index int, interaction recorder.Interaction) Result {
+	result := Result{
+		Index:       index,
+		Method:      interaction.Request.Method,
+		URL:         interaction.Request.URL,
+		Expected:    interaction.Response.Status,
+		Interaction: interaction,
+	}
+
+	req, err := r.buildRequest(interaction.Request)
+	if err != nil {
+		result.Error = err.Error()
+		result.errKind = "request"
+		return result
+	}
+	result.URL = req.URL.String()
+
+	start := time.Now()
+	resp, err := r.client.Do(req)
+	if err != nil {
+		result.Duration = time.Since(start)
+		result.Error = err.Error()
+		result.errKind = errclass.Classify(err)
+		return result
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	result.Duration = time.Since(start)
+	result.Status = resp.StatusCode
+	if err != nil {
+		result.Error = fmt.Sprintf("reading response body: %v", err)
+		result.errKind = errclass.Classify(err)
+		return result
+	}
+
+	live := recorder.NewInteraction(req, nil, resp, body, result.Duration, nil).Response
+	result.Response = &live
+	if r.chain != nil {
+		result.Captured = r.chain.observe(interaction.Response, live)
+	}
+	if r.opts.Assert {
+		result.Failures = r.assert(interaction.Response, *result.Response)
+	}
+	return result
+}
+
+func (r *Runner) buildRequest(detail recorder.ReqDetail) (*http.Request, error) {
+	body, err := detail.RawBody()
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded body: %w", err)
+	}
+	if r.chain != nil {
+		detail, body = r.chain.apply(detail, body)
+	}
+
+	req, err := http.NewRequest(detail.Method, detail.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, vv := range detail.Headers {
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+
+	body = r.rewriter.apply(req, body)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return req, nil
+}
+
+func (r *Runner) assert(expected, actual recorder.RespDetail) []diff.Change {
+	var failures []diff.Change
+	if expected.Status != actual.Status {
+		failures = append(failures, diff.Change{Kind: diff.KindChanged, Path: "status", Before: expected.Status, After: actual.Status})
+	}
+
+	for _, name := range r.opts.AssertHeaders {
+		want := http.Header(expected.Headers).Get(name)
+		got := http.Header(actual.Headers).Get(name)
+		if want != got {
+			failures = append(failures, diff.Change{Kind: diff.KindChanged, Path: "header " + http.CanonicalHeaderKey(name), Before: want, After: got})
+		}
+	}
+
+	return append(failures, diff.CompareBodies(expected, actual, r.diffOpts)...)
+}
@@ -0,0 +1,236 @@
+package replay
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mirage/internal/diff"
+)
+
+type Report struct {
+	Timestamp time.Time    `json:"timestamp"`
+	Summary   Summary      `json:"summary"`
+	Cases     []ReportCase `json:"cases"`
+}
+
+type Summary struct {
+	Total    int     `json:"total"`
+	Passed   int     `json:"passed"`
+	Failed   int     `json:"failed"`
+	Errors   int     `json:"errors"`
+	Omitted  int     `json:"omitted,omitempty"`
+	Duration float64 `json:"duration_ms"`
+}
+
+type ReportCase struct {
+	Name      string            `json:"name"`
+	Index     int               `json:"index"`
+	Iteration int               `json:"iteration,omitempty"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Status    int               `json:"status"`
+	Expected  int               `json:"expected_status"`
+	Duration  float64           `json:"duration_ms"`
+	Passed    bool              `json:"passed"`
+	Error     string            `json:"error,omitempty"`
+	ErrorKind string            `json:"error_kind,omitempty"`
+	Failures  []diff.Change     `json:"failures,omitempty"`
+	Captured  map[string]string `json:"captured,omitempty"`
+}
+
+func NewReport(results []Result, requests int, started time.Time) *Report {
+	report := &Report{Timestamp: started}
+	for _, r := range results {
+		if r.Warmup {
+			continue
+		}
+		c := ReportCase{
+			Name:      caseName(r),
+			Index:     r.Index + 1,
+			Iteration: r.Iteration,
+			Method:    r.Method,
+			URL:       r.URL,
+			Status:    r.Status,
+			Expected:  r.Expected,
+			Duration:  ms(r.Duration),
+			Passed:    r.Passed(),
+			Error:     r.Error,
+			ErrorKind: r.errKind,
+			Failures:  r.Failures,
+			Captured:  r.Captured,
+		}
+		report.Cases = append(report.Cases, c)
+
+		report.Summary.Total++
+		report.Summary.Duration += c.Duration
+		switch {
+		case c.Error != "":
+			report.Summary.Errors++
+		case len(c.Failures) > 0:
+			report.Summary.Failed++
+		default:
+			report.Summary.Passed++
+		}
+	}
+	report.Summary.Omitted = max(requests-report.Summary.Total, 0)
+	return report
+}
+
+func caseName(r Result) string {
+	name := fmt.Sprintf("#%d %s %s", r.Index+1, r.Method, r.URL)
+	if r.Iteration > 0 {
+		name += fmt.Sprintf(" (iteration %d)", r.Iteration+1)
+	}
+	return name
+}
+
+func ReportFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml":
+		return "junit", nil
+	case ".json":
+		return "json", nil
+	}
+	return "", fmt.Errorf("unknown report format for %s (use .xml for JUnit or .json)", path)
+}
+
+func (r *Report) WriteFile(path string) error {
+	format, err := ReportFormat(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if format == "junit" {
+		err = r.WriteJUnit(f)
+	} else {
+		err = r.WriteJSON(f)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+	SystemOut string      `xml:"system-out,omitempty"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (r *Report) WriteJUnit(w io.Writer) error {
+	suite := junitSuite{
+		Name:      "mirage replay",
+		Tests:     r.Summary.Total,
+		Failures:  r.Summary.Failed,
+		Errors:    r.Summary.Errors,
+		Time:      seconds(r.Summary.Duration),
+		Timestamp: r.Timestamp.UTC().Format("2006-01-02T15:04:05"),
+	}
+	if r.Summary.Omitted > 0 {
+		suite.SystemOut = fmt.Sprintf("%d cases omitted from this report\n", r.Summary.Omitted)
+	}
+
+	for _, c := range r.Cases {
+		jc := junitCase{
+			Name:      c.Name,
+			Classname: classname(c.URL),
+			Time:      seconds(c.Duration),
+			SystemOut: fmt.Sprintf("%s %s\nstatus: %d (recorded %d)\nduration: %.1fms\n", c.Method, c.URL, c.Status, c.Expected, c.Duration),
+		}
+		switch {
+		case c.Error != "":
+			jc.Error = &junitMessage{Message: c.Error, Type: c.ErrorKind, Text: c.Error}
+		case len(c.Failures) > 0:
+			lines := make([]string, len(c.Failures))
+			for i, f := range c.Failures {
+				lines[i] = f.String()
+			}
+			jc.Failure = &junitMessage{
+				Message: fmt.Sprintf("%d mismatches against the recording", len(c.Failures)),
+				Type:    "assertion",
+				Text:    strings.Join(lines, "\n"),
+			}
+		}
+		suite.Cases = append(suite.Cases, jc)
+	}
+
+	doc := junitSuites{
+		Name:     suite.Name,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Errors:   suite.Errors,
+		Time:     suite.Time,
+		Suites:   []junitSuite{suite},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func classname(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "mirage.replay"
+	}
+	return "mirage.replay." + strings.NewReplacer(".", "_", ":", "_").Replace(u.Host)
+}
+
+func seconds(millis float64) string {
+	return fmt.Sprintf("%.3f", millis/1000)
+}
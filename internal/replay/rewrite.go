@@ -0,0 +1,178 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"mirage/internal/content"
+)
+
+type substitution struct {
+	name     string
+	old, new string
+}
+
+type rewriter struct {
+	target      *url.URL
+	hosts       map[string]*url.URL
+	headers     http.Header
+	dropHeaders []string
+	paths       []substitution
+	bodies      []substitution
+}
+
+func newRewriter(opts Options) (*rewriter, error) {
+	rw := &rewriter{
+		hosts:       make(map[string]*url.URL),
+		headers:     make(http.Header),
+		dropHeaders: opts.DropHeaders,
+	}
+
+	if opts.Target != "" {
+		u, err := parseBase(opts.Target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --target %q: %w", opts.Target, err)
+		}
+		rw.target = u
+	}
+
+	for _, m := range opts.HostMap {
+		from, to, ok := strings.Cut(m, "=")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid host mapping %q (want old=new)", m)
+		}
+		u, err := parseBase(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host mapping %q: %w", m, err)
+		}
+		rw.hosts[strings.ToLower(from)] = u
+	}
+
+	for _, h := range opts.Headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q (want Name: value)", h)
+		}
+		rw.headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	var err error
+	if rw.paths, err = parseSubstitutions(opts.PathReplace); err != nil {
+		return nil, err
+	}
+	if rw.bodies, err = parseSubstitutions(opts.BodyReplace); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+func parseBase(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u, nil
+}
+
+func parseSubstitutions(specs []string) ([]substitution, error) {
+	subs := make([]substitution, 0, len(specs))
+	for _, spec := range specs {
+		old, new, ok := strings.Cut(spec, "=")
+		if !ok || old == "" {
+			return nil, fmt.Errorf("invalid substitution %q (want old=new)", spec)
+		}
+		subs = append(subs, substitution{old: old, new: new})
+	}
+	return subs, nil
+}
+
+func (rw *rewriter) apply(req *http.Request, body []byte) []byte {
+	u := req.URL
+	if base, ok := rw.hosts[strings.ToLower(u.Host)]; ok {
+		retarget(u, base)
+	} else if base, ok := rw.hosts[strings.ToLower(u.Hostname())]; ok {
+		retarget(u, base)
+	} else if rw.target != nil {
+		retarget(u, rw.target)
+	}
+
+	if len(rw.paths) > 0 {
+		p := u.EscapedPath()
+		for _, s := range rw.paths {
+			p = strings.ReplaceAll(p, s.old, s.new)
+		}
+		if parsed, err := url.Parse(p); err == nil {
+			u.Path, u.RawPath = parsed.Path, parsed.RawPath
+		}
+	}
+	req.Host = u.Host
+
+	for _, name := range rw.dropHeaders {
+		req.Header.Del(name)
+	}
+	for name, values := range rw.headers {
+		req.Header[name] = values
+	}
+
+	return replaceBody(req.Header.Get("Content-Encoding"), body, rw.bodies)
+}
+
+func replaceBody(contentEncoding string, body []byte, subs []substitution) []byte {
+	if len(subs) == 0 {
+		return body
+	}
+	return transformBody(contentEncoding, body, func(plain []byte) []byte {
+		for _, s := range subs {
+			plain = bytes.ReplaceAll(plain, []byte(s.old), []byte(s.new))
+		}
+		return plain
+	})
+}
+
+func transformBody(contentEncoding string, body []byte, fn func([]byte) []byte) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	compressed := content.Normalize(contentEncoding) != ""
+	plain := body
+	if compressed {
+		decoded, err := content.Decode(contentEncoding, body)
+		if err != nil {
+			return body
+		}
+		plain = decoded
+	}
+	replaced := fn(plain)
+	if bytes.Equal(replaced, plain) {
+		return body
+	}
+	if compressed {
+		encoded, err := content.Reencode(contentEncoding, body, replaced)
+		if err != nil {
+			return body
+		}
+		return encoded
+	}
+	return replaced
+}
+
+func retarget(u, base *url.URL) {
+	u.Scheme = base.Scheme
+	u.Host = base.Host
+	if base.Path != "" {
+		u.Path = base.Path + u.Path
+		if u.RawPath != "" {
+			u.RawPath = base.EscapedPath() + u.RawPath
+		}
+	}
+}
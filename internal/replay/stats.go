@@ -0,0 +1,171 @@
+package replay
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+)
+
+const maxLatencySamples = 100_000
+
+type Stats struct {
+	Requests   int            `json:"requests"`
+	Succeeded  int            `json:"succeeded"`
+	Failed     int            `json:"failed"`
+	Elapsed    float64        `json:"elapsed_ms"`
+	Throughput float64        `json:"throughput_rps"`
+	Latency    Latency        `json:"latency"`
+	Status     map[string]int `json:"status"`
+	Errors     map[string]int `json:"errors,omitempty"`
+}
+
+type Latency struct {
+	Min  float64 `json:"min_ms"`
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P95  float64 `json:"p95_ms"`
+	P99  float64 `json:"p99_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+type collector struct {
+	requests  int
+	succeeded int
+	failed    int
+	status    map[string]int
+	errors    map[string]int
+
+	timed     int
+	total     time.Duration
+	min, max  time.Duration
+	latencies []time.Duration
+}
+
+func newCollector() collector {
+	return collector{
+		status: make(map[string]int),
+		errors: make(map[string]int),
+	}
+}
+
+func (c *collector) add(res Result) {
+	if res.Warmup {
+		return
+	}
+	c.requests++
+	switch {
+	case res.Error != "":
+		c.failed++
+		c.errors[res.errKind]++
+	case len(res.Failures) > 0:
+		c.failed++
+		c.status[fmt.Sprint(res.Status)]++
+		c.errors["assertion"]++
+	default:
+		c.succeeded++
+		c.status[fmt.Sprint(res.Status)]++
+	}
+	if res.Error == "" {
+		c.addLatency(res.Duration)
+	}
+}
+
+func (c *collector) addLatency(d time.Duration) {
+	c.timed++
+	c.total += d
+	if c.timed == 1 || d < c.min {
+		c.min = d
+	}
+	if d > c.max {
+		c.max = d
+	}
+	if len(c.latencies) < maxLatencySamples {
+		c.latencies = append(c.latencies, d)
+		return
+	}
+	if i := rand.N(c.timed); i < maxLatencySamples {
+		c.latencies[i] = d
+	}
+}
+
+func (r *Runner) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := &r.stats
+	stats := Stats{
+		Requests:  c.requests,
+		Succeeded: c.succeeded,
+		Failed:    c.failed,
+		Status:    make(map[string]int, len(c.status)),
+		Errors:    make(map[string]int, len(c.errors)),
+		Elapsed:   ms(r.measured),
+	}
+	for k, v := range c.status {
+		stats.Status[k] = v
+	}
+	for k, v := range c.errors {
+		stats.Errors[k] = v
+	}
+
+	if r.measured > 0 {
+		stats.Throughput = float64(stats.Requests) / r.measured.Seconds()
+	}
+	if c.timed > 0 {
+		latencies := append([]time.Duration(nil), c.latencies...)
+		sort.Slice(latencies, func(a, b int) bool { return latencies[a] < latencies[b] })
+		stats.Latency = Latency{
+			Min:  ms(c.min),
+			Mean: ms(c.total / time.Duration(c.timed)),
+			P50:  ms(percentile(latencies, 50)),
+			P90:  ms(percentile(latencies, 90)),
+			P95:  ms(percentile(latencies, 95)),
+			P99:  ms(percentile(latencies, 99)),
+			Max:  ms(c.max),
+		}
+	}
+	return stats
+}
+
+func (s Stats) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Requests:    %d (%d ok, %d failed) in %.2fs\n", s.Requests, s.Succeeded, s.Failed, s.Elapsed/1000)
+	fmt.Fprintf(w, "Throughput:  %.2f req/s\n", s.Throughput)
+	fmt.Fprintf(w, "Latency:     min %.1fms  mean %.1fms  max %.1fms\n", s.Latency.Min, s.Latency.Mean, s.Latency.Max)
+	fmt.Fprintf(w, "             p50 %.1fms  p90 %.1fms  p95 %.1fms  p99 %.1fms\n", s.Latency.P50, s.Latency.P90, s.Latency.P95, s.Latency.P99)
+	if len(s.Status) > 0 {
+		fmt.Fprintf(w, "Status:      %s\n", formatCounts(s.Status))
+	}
+	if len(s.Errors) > 0 {
+		fmt.Fprintf(w, "Errors:      %s\n", formatCounts(s.Errors))
+	}
+}
+
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", k, counts[k])
+	}
+	return strings.Join(parts, "  ")
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
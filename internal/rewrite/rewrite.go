@@ -0,0 +1,307 @@
+package rewrite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mirage/internal/config"
+	"mirage/internal/content"
+	"mirage/internal/jsonpath"
+	"mirage/internal/scenario"
+)
+
+type Engine struct {
+	rules []*rule
+}
+
+type rule struct {
+	name     string
+	match    config.Match
+	request  config.RequestRewrite
+	response config.ResponseRewrite
+	baseURL  *url.URL
+	paths    []replacement
+	reqBody  bodyEdit
+	respBody bodyEdit
+}
+
+type replacement struct {
+	re   *regexp.Regexp
+	with string
+}
+
+type jsonSet struct {
+	path  jsonpath.Path
+	value any
+}
+
+type bodyEdit struct {
+	replace []replacement
+	set     []jsonSet
+	delete  []jsonpath.Path
+}
+
+type Applied []*rule
+
+func New(rewrites []config.Rewrite) (*Engine, error) {
+	if len(rewrites) == 0 {
+		return nil, nil
+	}
+
+	e := &Engine{}
+	for i, rw := range rewrites {
+		name := rw.Name
+		if name == "" {
+			name = fmt.Sprintf("rewrites[%d]", i)
+		}
+		r, err := compile(name, rw)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite %s: %w", name, err)
+		}
+		e.rules = append(e.rules, r)
+	}
+	return e, nil
+}
+
+func compile(name string, rw config.Rewrite) (*rule, error) {
+	r := &rule{name: name, match: rw.Match, request: rw.Request, response: rw.Response}
+
+	if rw.Request.URL != "" {
+		u, err := url.Parse(rw.Request.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("request.url %q must be an absolute URL", rw.Request.URL)
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		r.baseURL = u
+	}
+
+	var err error
+	if r.paths, err = compileReplacements("request.path_replace", rw.Request.PathReplace); err != nil {
+		return nil, err
+	}
+	if r.reqBody, err = compileBodyEdit("request", rw.Request.BodyEdit); err != nil {
+		return nil, err
+	}
+	if r.respBody, err = compileBodyEdit("response", rw.Response.BodyEdit); err != nil {
+		return nil, err
+	}
+	if rw.Response.Status != 0 && (rw.Response.Status < 100 || rw.Response.Status > 999) {
+		return nil, fmt.Errorf("response.status %d is invalid", rw.Response.Status)
+	}
+	return r, nil
+}
+
+func compileReplacements(field string, specs []config.Replace) ([]replacement, error) {
+	var out []replacement
+	for _, spec := range specs {
+		re, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern %q: %w", field, spec.Pattern, err)
+		}
+		out = append(out, replacement{re: re, with: spec.With})
+	}
+	return out, nil
+}
+
+func compileBodyEdit(field string, cfg config.BodyEdit) (bodyEdit, error) {
+	var edit bodyEdit
+	var err error
+	if edit.replace, err = compileReplacements(field+".body_replace", cfg.BodyReplace); err != nil {
+		return edit, err
+	}
+	exprs := make([]string, 0, len(cfg.JSONSet))
+	for expr := range cfg.JSONSet {
+		exprs = append(exprs, expr)
+	}
+	sort.Strings(exprs)
+	for _, expr := range exprs {
+		p, err := jsonpath.Parse(expr)
+		if err != nil {
+			return edit, fmt.Errorf("%s.json_set: %w", field, err)
+		}
+		edit.set = append(edit.set, jsonSet{path: p, value: cfg.JSONSet[expr]})
+	}
+	for _, expr := range cfg.JSONDelete {
+		p, err := jsonpath.Parse(expr)
+		if err != nil {
+			return edit, fmt.Errorf("%s.json_delete: %w", field, err)
+		}
+		edit.delete = append(edit.delete, p)
+	}
+	return edit, nil
+}
+
+func (e *Engine) Match(r *http.Request) Applied {
+	if e == nil {
+		return nil
+	}
+	var applied Applied
+	for _, rule := range e.rules {
+		if scenario.MatchRequest(rule.match, r) {
+			applied = append(applied, rule)
+		}
+	}
+	return applied
+}
+
+func (a Applied) Names() []string {
+	names := make([]string, len(a))
+	for i, r := range a {
+		names[i] = r.name
+	}
+	return names
+}
+
+func (a Applied) Request(req *http.Request, body []byte) []byte {
+	for _, r := range a {
+		rw := r.request
+		if r.baseURL != nil {
+			req.URL.Scheme = r.baseURL.Scheme
+			req.URL.Host = r.baseURL.Host
+			if r.baseURL.Path != "" {
+				req.URL.Path = r.baseURL.Path + req.URL.Path
+				req.URL.RawPath = ""
+			}
+			req.Host = r.baseURL.Host
+		}
+		for _, p := range r.paths {
+			req.URL.Path = p.re.ReplaceAllString(req.URL.Path, p.with)
+			req.URL.RawPath = ""
+		}
+
+		for _, name := range rw.RemoveHeaders {
+			req.Header.Del(name)
+		}
+		if len(rw.RemoveCookies) > 0 {
+			removeCookies(req, rw.RemoveCookies)
+		}
+		for name, value := range rw.SetHeaders {
+			if strings.EqualFold(name, "Host") {
+				req.Host = value
+				continue
+			}
+			req.Header.Set(name, value)
+		}
+
+		body = r.reqBody.apply(req.Header, body)
+	}
+
+	return body
+}
+
+func (a Applied) Response(resp *http.Response, body []byte) []byte {
+	for _, r := range a {
+		rw := r.response
+		if rw.Status != 0 {
+			resp.StatusCode = rw.Status
+			resp.Status = fmt.Sprintf("%d %s", rw.Status, http.StatusText(rw.Status))
+		}
+		for _, name := range rw.RemoveHeaders {
+			resp.Header.Del(name)
+		}
+		for name, value := range rw.SetHeaders {
+			resp.Header.Set(name, value)
+		}
+		body = r.respBody.apply(resp.Header, body)
+	}
+
+	resp.ContentLength = int64(len(body))
+	if resp.Header.Get("Content-Length") != "" {
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return body
+}
+
+func (e bodyEdit) empty() bool {
+	return len(e.replace) == 0 && len(e.set) == 0 && len(e.delete) == 0
+}
+
+func (e bodyEdit) apply(header http.Header, body []byte) []byte {
+	if e.empty() {
+		return body
+	}
+
+	encoding := header.Get("Content-Encoding")
+	compressed := content.Normalize(encoding) != ""
+	plain := body
+	if compressed {
+		decoded, err := content.Decode(encoding, body)
+		if err != nil {
+			return body
+		}
+		plain = decoded
+	}
+
+	edited := plain
+	if len(e.set) > 0 || len(e.delete) > 0 {
+		edited = e.editJSON(edited)
+	}
+	for _, r := range e.replace {
+		edited = r.re.ReplaceAll(edited, []byte(r.with))
+	}
+	if bytes.Equal(edited, plain) {
+		return body
+	}
+
+	if compressed {
+		encoded, err := content.Reencode(encoding, body, edited)
+		if err != nil {
+			header.Del("Content-Encoding")
+			return edited
+		}
+		return encoded
+	}
+	return edited
+}
+
+func (e bodyEdit) editJSON(body []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return body
+	}
+
+	for _, s := range e.set {
+		doc = s.path.Set(doc, s.value)
+	}
+	for _, p := range e.delete {
+		doc, _ = p.Delete(doc)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if bytes.Contains(body, []byte("\n")) {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(doc); err != nil {
+		return body
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+func removeCookies(req *http.Request, names []string) {
+	drop := make(map[string]bool, len(names))
+	for _, n := range names {
+		drop[n] = true
+	}
+
+	var kept []string
+	for _, c := range req.Cookies() {
+		if !drop[c.Name] {
+			kept = append(kept, c.String())
+		}
+	}
+	req.Header.Del("Cookie")
+	if len(kept) > 0 {
+		req.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
@@ -9,6 +9,7 @@ import (
 type RuntimeScenario struct {
 	config.Scenario
 	Enabled bool
+	Route   string `json:",omitempty"`
 }
 
 type Matcher struct {
@@ -27,10 +28,17 @@ func NewMatcher(scenarios []config.Scenario) *Matcher {
 }
 
 func (m *Matcher) Match(r *http.Request) *config.Scenario {
+	return m.MatchOnly(r, nil)
+}
+
+func (m *Matcher) MatchOnly(r *http.Request, names map[string]bool) *config.Scenario {
 	for _, s := range m.Scenarios {
 		if !s.Enabled {
 			continue
 		}
+		if names != nil && !names[s.Name] {
+			continue
+		}
 		if matches(&s.Scenario, r) {
 			return &s.Scenario
 		}
@@ -57,19 +65,23 @@ func (m *Matcher) GetScenarios() []RuntimeScenario {
 }
 
 func matches(s *config.Scenario, r *http.Request) bool {
-	if s.Match.Method != "" && s.Match.Method != r.Method {
+	return MatchRequest(s.Match, r)
+}
+
+func MatchRequest(m config.Match, r *http.Request) bool {
+	if m.Method != "" && m.Method != r.Method {
 		return false
 	}
 
 	reqPath := r.URL.Path
-	if s.Match.Path != "" {
-		matched, _ := filepath.Match(s.Match.Path, reqPath)
-		if !matched && s.Match.Path != reqPath {
+	if m.Path != "" {
+		matched, _ := filepath.Match(m.Path, reqPath)
+		if !matched && m.Path != reqPath {
 			return false
 		}
 	}
 
-	for k, v := range s.Match.Headers {
+	for k, v := range m.Headers {
 		if r.Header.Get(k) != v {
 			return false
 		}
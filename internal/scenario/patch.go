@@ -0,0 +1,94 @@
+package scenario
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mirage/internal/config"
+	"mirage/internal/content"
+	"mirage/internal/jsonpatch"
+)
+
+func PatchResponse(ctx context.Context, resp *http.Response, body []byte, s *config.Scenario) ([]byte, error) {
+	r := s.Response
+	if r.Delay > 0 {
+		select {
+		case <-time.After(r.Delay):
+		case <-ctx.Done():
+		}
+	}
+
+	if r.Status != 0 {
+		resp.StatusCode = r.Status
+		resp.Status = fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status))
+	}
+	for k, v := range r.Headers {
+		if v == "" {
+			resp.Header.Del(k)
+			continue
+		}
+		resp.Header.Set(k, v)
+	}
+
+	if len(r.JSONPatch) == 0 && r.MergePatch == nil {
+		return body, nil
+	}
+
+	patched, err := patchBody(resp.Header.Get("Content-Encoding"), body, r)
+	if err != nil {
+		return body, err
+	}
+	resp.ContentLength = int64(len(patched))
+	if resp.Header.Get("Content-Length") != "" {
+		resp.Header.Set("Content-Length", strconv.Itoa(len(patched)))
+	}
+	return patched, nil
+}
+
+func patchBody(contentEncoding string, body []byte, r config.Response) ([]byte, error) {
+	compressed := content.Normalize(contentEncoding) != ""
+	plain := body
+	if compressed {
+		decoded, err := content.Decode(contentEncoding, body)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s body: %w", contentEncoding, err)
+		}
+		plain = decoded
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(plain))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("upstream body is not JSON: %w", err)
+	}
+
+	if r.MergePatch != nil {
+		doc = jsonpatch.MergePatch(doc, r.MergePatch)
+	}
+	doc, err := jsonpatch.Apply(doc, r.JSONPatch)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if bytes.Contains(plain, []byte("\n")) {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	patched := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+	if compressed {
+		return content.Reencode(contentEncoding, body, patched)
+	}
+	return patched, nil
+}
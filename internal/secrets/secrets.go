@@ -0,0 +1,94 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const minLength = 4
+
+var (
+	mu       sync.RWMutex
+	labels   = make(map[string]string)
+	replacer *strings.Replacer
+)
+
+func Register(label, value string) {
+	if len(value) < minLength {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	labels[value] = label
+	replacer = buildReplacer()
+}
+
+func Mask(s string) string {
+	mu.RLock()
+	r := replacer
+	mu.RUnlock()
+
+	if r == nil || s == "" {
+		return s
+	}
+	return r.Replace(s)
+}
+
+func MaskBytes(b []byte) []byte {
+	mu.RLock()
+	r := replacer
+	mu.RUnlock()
+
+	if r == nil || len(b) == 0 {
+		return b
+	}
+	return []byte(r.Replace(string(b)))
+}
+
+func buildReplacer() *strings.Replacer {
+	masks := make(map[string]string, len(labels))
+	for v, label := range labels {
+		for _, form := range encodings(v) {
+			masks[form] = "[masked:" + label + "]"
+		}
+	}
+
+	values := make([]string, 0, len(masks))
+	for v := range masks {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+
+	pairs := make([]string, 0, len(values)*2)
+	for _, v := range values {
+		pairs = append(pairs, v, masks[v])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+func encodings(value string) []string {
+	forms := []string{value}
+	for _, escapeHTML := range []bool{true, false} {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(escapeHTML)
+		if err := enc.Encode(value); err != nil {
+			continue
+		}
+		quoted := strings.TrimSpace(buf.String())
+		if escaped := quoted[1 : len(quoted)-1]; escaped != value {
+			forms = append(forms, escaped)
+		}
+	}
+	return forms
+}
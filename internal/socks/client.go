@@ -0,0 +1,99 @@
+package socks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+func Connect(conn net.Conn, target, username, password string) error {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port in %q", target)
+	}
+
+	method := byte(methodNoAuth)
+	if username != "" {
+		method = methodUserPass
+	}
+	if _, err := conn.Write([]byte{version5, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != version5 || reply[1] != method {
+		return errors.New("SOCKS proxy rejected the authentication method")
+	}
+
+	if method == methodUserPass {
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("SOCKS credentials are too long")
+		}
+		auth := []byte{0x01, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("SOCKS proxy rejected the credentials")
+		}
+	}
+
+	req := []byte{version5, cmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(append(req, atypIPv4), ip4...)
+		} else {
+			req = append(append(req, atypIPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q is too long", host)
+		}
+		req = append(req, atypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != replySucceeded {
+		return fmt.Errorf("SOCKS proxy could not connect to %s (reply %d)", target, header[1])
+	}
+	var skip int
+	switch header[3] {
+	case atypIPv4:
+		skip = net.IPv4len
+	case atypIPv6:
+		skip = net.IPv6len
+	case atypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("SOCKS proxy sent unknown address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
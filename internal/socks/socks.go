@@ -0,0 +1,455 @@
+package socks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	version5 = 0x05
+
+	methodNoAuth       = 0x00
+	methodUserPass     = 0x02
+	methodNoAcceptable = 0xff
+
+	cmdConnect = 0x01
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	replySucceeded           = 0x00
+	replyCommandNotSupported = 0x07
+	replyAddressNotSupported = 0x08
+
+	sniffTimeout = 300 * time.Millisecond
+	dialTimeout  = 10 * time.Second
+)
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "), []byte("PATCH "),
+	[]byte("HEAD "), []byte("OPTIONS "), []byte("TRACE "), []byte("CONNECT "),
+}
+
+type Tunnel struct {
+	Client   string
+	Target   string
+	Sent     int64
+	Received int64
+	Duration time.Duration
+	Err      error
+}
+
+type Server struct {
+	Addr     string
+	Handler  http.Handler
+	Username string
+	Password string
+	Dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	OnTunnel func(Tunnel)
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	servers  map[*http.Server]struct{}
+	closing  bool
+	active   sync.WaitGroup
+}
+
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer s.untrack(conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.active.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.active.Done()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for srv := range s.servers {
+		go srv.Shutdown(ctx)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	target, err := s.handshake(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	if err := writeReply(conn, replySucceeded); err != nil {
+		conn.Close()
+		return
+	}
+
+	br := bufio.NewReader(conn)
+	if isHTTP(conn, br) {
+		s.serveHTTP(&bufferedConn{Conn: conn, r: br}, target)
+		return
+	}
+
+	start := time.Now()
+	upstream, err := s.dial(target)
+	if err != nil {
+		conn.Close()
+		s.report(Tunnel{Client: conn.RemoteAddr().String(), Target: target, Duration: time.Since(start), Err: err})
+		return
+	}
+	s.tunnel(&bufferedConn{Conn: conn, r: br}, upstream, target, start)
+}
+
+func (s *Server) dial(target string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if s.Dial != nil {
+		return s.Dial(ctx, "tcp", target)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", target)
+}
+
+func (s *Server) handshake(conn net.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != version5 {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(methodNoAuth)
+	if s.Username != "" {
+		method = methodUserPass
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		conn.Write([]byte{version5, methodNoAcceptable})
+		return "", errors.New("no acceptable authentication method")
+	}
+	if _, err := conn.Write([]byte{version5, method}); err != nil {
+		return "", err
+	}
+	if method == methodUserPass {
+		if err := s.authenticate(conn); err != nil {
+			return "", err
+		}
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return "", err
+	}
+	if req[1] != cmdConnect {
+		writeReply(conn, replyCommandNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", req[1])
+	}
+
+	var host string
+	switch req[3] {
+	case atypIPv4, atypIPv6:
+		size := net.IPv4len
+		if req[3] == atypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case atypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		writeReply(conn, replyAddressNotSupported)
+		return "", fmt.Errorf("unsupported address type %d", req[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func (s *Server) authenticate(conn net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	user := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return err
+	}
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return err
+	}
+	pass := make([]byte, length[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return err
+	}
+
+	if string(user) != s.Username || string(pass) != s.Password {
+		conn.Write([]byte{0x01, 0x01})
+		return errors.New("invalid SOCKS credentials")
+	}
+	_, err := conn.Write([]byte{0x01, 0x00})
+	return err
+}
+
+func writeReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{version5, code, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func isHTTP(conn net.Conn, br *bufio.Reader) bool {
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for _, m := range httpMethods {
+		peek, _ := br.Peek(len(m))
+		if len(peek) == 0 {
+			return false
+		}
+		if bytes.Equal(peek, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) serveHTTP(conn *bufferedConn, target string) {
+	host, port, _ := net.SplitHostPort(target)
+	authority := target
+	if port == "80" {
+		authority = host
+		if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			authority = "[" + host + "]"
+		}
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host == "" {
+			r.URL.Scheme = "http"
+			r.URL.Host = authority
+		}
+		s.Handler.ServeHTTP(w, r)
+	})
+
+	l := newSingleListener(conn)
+	srv := &http.Server{Handler: handler}
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	if s.servers == nil {
+		s.servers = make(map[*http.Server]struct{})
+	}
+	s.servers[srv] = struct{}{}
+	s.mu.Unlock()
+
+	srv.Serve(l)
+
+	s.mu.Lock()
+	delete(s.servers, srv)
+	s.mu.Unlock()
+}
+
+func (s *Server) tunnel(client *bufferedConn, upstream net.Conn, target string, start time.Time) {
+	t := Tunnel{Client: client.RemoteAddr().String(), Target: target}
+
+	var sent, received atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		n, _ := io.Copy(upstream, client)
+		sent.Store(n)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		n, _ := io.Copy(client, upstream)
+		received.Store(n)
+		closeWrite(client.Conn)
+	}()
+	wg.Wait()
+	upstream.Close()
+	client.Close()
+
+	t.Sent = sent.Load()
+	t.Received = received.Load()
+	t.Duration = time.Since(start)
+	s.report(t)
+}
+
+func (s *Server) report(t Tunnel) {
+	if s.OnTunnel != nil {
+		s.OnTunnel(t)
+	}
+}
+
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+type singleListener struct {
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func newSingleListener(conn net.Conn) *singleListener {
+	l := &singleListener{done: make(chan struct{})}
+	l.conn = &notifyConn{Conn: conn, closed: l.done}
+	return l
+}
+
+func (l *singleListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() { conn = l.conn })
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *singleListener) Close() error {
+	return nil
+}
+
+func (l *singleListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+type notifyConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *notifyConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,191 @@
+package socks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestHandshake(t *testing.T) {
+	connectIPv4 := []byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x50}
+	noAuth := []byte{0x05, 0x01, 0x00}
+	failure := func(code byte) []byte {
+		return []byte{0x05, code, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		input    []byte
+		want     string
+		wantErr  bool
+		wantOut  []byte
+	}{
+		{
+			name:    "ipv4",
+			input:   join(noAuth, connectIPv4),
+			want:    "127.0.0.1:80",
+			wantOut: []byte{0x05, 0x00},
+		},
+		{
+			name:    "domain",
+			input:   join(noAuth, []byte{0x05, 0x01, 0x00, 0x03, 11}, []byte("example.com"), []byte{0x01, 0xbb}),
+			want:    "example.com:443",
+			wantOut: []byte{0x05, 0x00},
+		},
+		{
+			name:    "ipv6",
+			input:   join(noAuth, []byte{0x05, 0x01, 0x00, 0x04}, net.IPv6loopback, []byte{0x1f, 0x90}),
+			want:    "[::1]:8080",
+			wantOut: []byte{0x05, 0x00},
+		},
+		{
+			name:     "auth",
+			username: "user",
+			password: "secret",
+			input:    join([]byte{0x05, 0x02, 0x00, 0x02, 0x01, 4}, []byte("user"), []byte{6}, []byte("secret"), connectIPv4),
+			want:     "127.0.0.1:80",
+			wantOut:  []byte{0x05, 0x02, 0x01, 0x00},
+		},
+		{
+			name:     "wrong password",
+			username: "user",
+			password: "secret",
+			input:    join([]byte{0x05, 0x01, 0x02, 0x01, 4}, []byte("user"), []byte{5}, []byte("wrong"), connectIPv4),
+			wantErr:  true,
+			wantOut:  []byte{0x05, 0x02, 0x01, 0x01},
+		},
+		{
+			name:     "auth not offered",
+			username: "user",
+			password: "secret",
+			input:    join(noAuth, connectIPv4),
+			wantErr:  true,
+			wantOut:  []byte{0x05, 0xff},
+		},
+		{
+			name:    "unsupported command",
+			input:   join(noAuth, []byte{0x05, 0x02, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x50}),
+			wantErr: true,
+			wantOut: join([]byte{0x05, 0x00}, failure(0x07)),
+		},
+		{
+			name:    "unsupported address type",
+			input:   join(noAuth, []byte{0x05, 0x01, 0x00, 0x05, 0x00}),
+			wantErr: true,
+			wantOut: join([]byte{0x05, 0x00}, failure(0x08)),
+		},
+		{
+			name:    "socks4",
+			input:   []byte{0x04, 0x01, 0x00, 0x50, 127, 0, 0, 1, 0x00},
+			wantErr: true,
+			wantOut: []byte{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+
+			go client.Write(tt.input)
+			out := make(chan []byte)
+			go func() {
+				data, _ := io.ReadAll(client)
+				out <- data
+			}()
+
+			s := &Server{Username: tt.username, Password: tt.password}
+			target, err := s.handshake(server)
+			server.Close()
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if target != tt.want {
+				t.Errorf("target = %q, want %q", target, tt.want)
+			}
+			if got := <-out; !bytes.Equal(got, tt.wantOut) {
+				t.Errorf("client got % x, want % x", got, tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestServeConnDialsOnlyTunnels(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		dialErr  error
+		wantDial bool
+		wantResp string
+		wantErr  bool
+	}{
+		{name: "http to unreachable host", payload: "GET /users HTTP/1.1\r\nHost: mock.test\r\nConnection: close\r\n\r\n", dialErr: errors.New("no such host"), wantResp: "HTTP/1.1 200 OK"},
+		{name: "tunnel dial failure", payload: "\x16\x03\x01", dialErr: errors.New("connection refused"), wantDial: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dialed atomic.Bool
+			tunnels := make(chan Tunnel, 1)
+			s := &Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(r.URL.String()))
+				}),
+				Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					dialed.Store(true)
+					return nil, tt.dialErr
+				},
+				OnTunnel: func(t Tunnel) { tunnels <- t },
+			}
+
+			client, server := net.Pipe()
+			defer client.Close()
+			go s.serveConn(server)
+
+			if err := Connect(client, "mock.test:80", "", ""); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := client.Write([]byte(tt.payload)); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantResp != "" {
+				line, err := bufio.NewReader(client).ReadString('\n')
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.HasPrefix([]byte(line), []byte(tt.wantResp)) {
+					t.Errorf("response = %q, want %q", line, tt.wantResp)
+				}
+			}
+			if tt.wantErr {
+				select {
+				case tun := <-tunnels:
+					if tun.Err == nil || tun.Target != "mock.test:80" {
+						t.Errorf("tunnel = %+v, want a failed tunnel to mock.test:80", tun)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("no tunnel reported")
+				}
+			}
+			if got := dialed.Load(); got != tt.wantDial {
+				t.Errorf("dialed = %v, want %v", got, tt.wantDial)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package timing
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+type Phases struct {
+	DNS      time.Duration `json:"dns"`
+	Connect  time.Duration `json:"connect"`
+	TLS      time.Duration `json:"tls"`
+	Wait     time.Duration `json:"wait"`
+	TTFB     time.Duration `json:"ttfb"`
+	Transfer time.Duration `json:"transfer"`
+	Total    time.Duration `json:"total"`
+	Reused   bool          `json:"reused"`
+}
+
+type Tracer struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	done         time.Time
+	reused       bool
+}
+
+func NewTracer() *Tracer {
+	return &Tracer{start: time.Now()}
+}
+
+func (t *Tracer) Trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mark(&t.dnsStart)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mark(&t.dnsDone)
+		},
+		ConnectStart: func(string, string) {
+			t.markOnce(&t.connectStart)
+		},
+		ConnectDone: func(string, string, error) {
+			t.mark(&t.connectDone)
+		},
+		TLSHandshakeStart: func() {
+			t.mark(&t.tlsStart)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mark(&t.tlsDone)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mark(&t.wroteRequest)
+		},
+		GotFirstResponseByte: func() {
+			t.mark(&t.firstByte)
+		},
+	}
+}
+
+func (t *Tracer) Done() {
+	t.mark(&t.done)
+}
+
+func (t *Tracer) Phases() *Phases {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	done := t.done
+	if done.IsZero() {
+		done = time.Now()
+	}
+
+	p := &Phases{
+		DNS:     between(t.dnsStart, t.dnsDone),
+		Connect: between(t.connectStart, t.connectDone),
+		TLS:     between(t.tlsStart, t.tlsDone),
+		Wait:    between(t.wroteRequest, t.firstByte),
+		TTFB:    between(t.start, t.firstByte),
+		Total:   done.Sub(t.start),
+		Reused:  t.reused,
+	}
+	if !t.firstByte.IsZero() {
+		p.Transfer = done.Sub(t.firstByte)
+	}
+	return p
+}
+
+func (t *Tracer) mark(field *time.Time) {
+	t.mu.Lock()
+	*field = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *Tracer) markOnce(field *time.Time) {
+	t.mu.Lock()
+	if field.IsZero() {
+		*field = time.Now()
+	}
+	t.mu.Unlock()
+}
+
+func between(from, to time.Time) time.Duration {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
@@ -4,9 +4,10 @@ import (
 	_ "embed"
 	"encoding/json"
 	"net/http"
-	
+
 	"mirage/internal/proxy"
-	
+	"mirage/internal/secrets"
+
 	"github.com/gorilla/mux"
 )
 
@@ -23,10 +24,16 @@ func NewUI(p *proxy.Proxy) *UI {
 
 func (u *UI) Handler() http.Handler {
 	r := mux.NewRouter()
+	r.Handle("/", http.RedirectHandler("/__mirage/", http.StatusFound))
 	r.HandleFunc("/__mirage/", u.handleDashboard).Methods("GET")
 	r.HandleFunc("/__mirage/api/requests", u.handleRequests).Methods("GET")
 	r.HandleFunc("/__mirage/api/scenarios", u.handleScenarios).Methods("GET")
 	r.HandleFunc("/__mirage/api/scenarios/{name}/toggle", u.handleToggle).Methods("POST")
+	r.HandleFunc("/__mirage/api/recording", u.handleRecording).Methods("GET")
+	r.HandleFunc("/__mirage/api/recording", u.handleSetRecording).Methods("POST")
+	r.HandleFunc("/__mirage/api/cassette", u.handleCassette).Methods("GET")
+	r.HandleFunc("/__mirage/api/cassette", u.handleInsertCassette).Methods("POST")
+	r.HandleFunc("/__mirage/api/cassette", u.handleEjectCassette).Methods("DELETE")
 	return r
 }
 
@@ -37,24 +44,23 @@ func (u *UI) handleDashboard(w http.ResponseWriter, r *http.Request) {
 
 func (u *UI) handleRequests(w http.ResponseWriter, r *http.Request) {
 	logs := u.proxy.GetRecentRequests()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(logs)
+	writeJSON(w, logs)
 }
 
 func (u *UI) handleScenarios(w http.ResponseWriter, r *http.Request) {
 	scenarios := u.proxy.GetScenarios()
-	w.Header().Set("Content-Type", "application/json")
 	if scenarios == nil {
+		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte("[]"))
 		return
 	}
-	json.NewEncoder(w).Encode(scenarios)
+	writeJSON(w, scenarios)
 }
 
 func (u *UI) handleToggle(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
-	
+
 	var body struct {
 		Enabled bool `json:"enabled"`
 	}
@@ -62,12 +68,82 @@ func (u *UI) handleToggle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	
-	success := u.proxy.ToggleScenario(name, body.Enabled)
+
+	success := u.proxy.ToggleScenario(r.URL.Query().Get("route"), name, body.Enabled)
 	if !success {
 		http.Error(w, "Scenario not found", http.StatusNotFound)
 		return
 	}
-	
+
 	w.WriteHeader(http.StatusOK)
 }
+
+func (u *UI) handleRecording(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, u.proxy.Recording())
+}
+
+func (u *UI) handleSetRecording(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := u.proxy.SetRecording(body.Enabled); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, u.proxy.Recording())
+}
+
+func (u *UI) handleCassette(w http.ResponseWriter, r *http.Request) {
+	tape := u.proxy.Cassettes().Active()
+	if tape == nil {
+		writeJSON(w, nil)
+		return
+	}
+	writeJSON(w, tape.Status())
+}
+
+func (u *UI) handleInsertCassette(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+		Mode string `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tape, err := u.proxy.Cassettes().Insert(body.Name, body.Mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, tape.Status())
+}
+
+func (u *UI) handleEjectCassette(w http.ResponseWriter, r *http.Request) {
+	tape, err := u.proxy.Cassettes().Eject(r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tape == nil {
+		http.Error(w, "No cassette inserted", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, tape.Status())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(secrets.MaskBytes(data))
+}